@@ -5,144 +5,350 @@ import (
 	"reflect"
 )
 
-func autoProvide(typ reflect.Type) (initializer, error) {
+type providedField struct {
+	Type           reflect.Type
+	Index          int
+	MustBeComplete bool
+	Deref          bool
+}
+
+// ValidateType checks the provide tags on the fields of a struct type
+// (or a pointer to a struct type), along with the signature of its PleaseProvide method,
+// without constructing anything. Use it to catch mistakes in automatically-provided
+// types at startup or in tests rather than the first time the type is provided:
+//
+//     func TestTypes(t *testing.T) {
+//         if err := provide.ValidateType(reflect.TypeOf((*Server)(nil))); err != nil {
+//             t.Fatal(err)
+//         }
+//     }
+//
+func ValidateType(t reflect.Type) error {
+	return validateType(t, "")
+}
+
+// validateType is like ValidateType, but also accepts fields tagged with defaultTag (see SetDefaultTag).
+func validateType(t reflect.Type, defaultTag string) error {
+	elem := t
+	if t.Kind() == reflect.Ptr {
+		elem = t.Elem()
+	}
+
+	if _, err := findProvidedFields(elem, defaultTag); err != nil {
+		return err
+	}
+	_, _, _, err := findInitFn(reflect.PtrTo(elem))
+	return err
+}
+
+// autoProvide automatically provides a type.
+// Fields tagged with defaultTag are treated the same as fields tagged with the empty string.
+func autoProvide(typ reflect.Type, defaultTag string) (initializer, error) {
 	switch typ.Kind() {
 	case reflect.Interface:
 		return initializer{}, errors.New(typ.String() + " can't be automatically provided")
 
 	case reflect.Ptr:
-		type Field struct {
-			Type           reflect.Type
-			Index          int
-			MustBeComplete bool
-		}
-
-		var providedFields []Field
-		elem := typ.Elem()
-		if elem.Kind() == reflect.Struct {
-			N := elem.NumField()
-			for i := 0; i < N; i++ {
-				field := elem.Field(i)
-				tag, ok := field.Tag.Lookup("provide")
-				if !ok {
-					continue
-				}
+		if typ.Elem().Kind() == reflect.Ptr {
+			return initializer{}, errors.New(
+				"pointer-to-pointer type " + typ.String() + " is not supported by auto-provide; add a rule for it instead",
+			)
+		}
+		return autoProvidePtr(typ, defaultTag, false)
 
-				allowCircular := (tag == "circular")
-				if !allowCircular {
-					if tag != "" {
-						return initializer{}, errors.New(
-							"unrecognized provide tag " + tag + " in " + elem.String(),
-						)
-					}
-				} else {
-					if !isReferenceType(field.Type) {
-						return initializer{}, errors.New(
-							"only reference types (pointer, map, chan) can be circularly provided to " + elem.String() + ", not " + field.Type.String(),
-						)
-					}
+	default:
+		if !providedThroughPtr(typ) {
+			return autoProvideValue(typ, defaultTag)
+		}
+		return autoDeref(typ), nil
+	}
+}
+
+// autoDeref automatically provides a non-pointer type by dereferencing a pointer to it.
+func autoDeref(typ reflect.Type) initializer {
+	ptrTo := reflect.PtrTo(typ)
+	return initializer{
+		Type: typ,
+		Partial: state{
+			DependsOn: []task{{ptrTo, true}},
+			Do: func(values map[reflect.Type]reflect.Value) error {
+				vptr := values[ptrTo]
+				if vptr.IsNil() {
+					return errors.New("can't use nil pointer to automatically provide value for " + typ.String())
 				}
 
-				providedFields = append(providedFields, Field{
-					Type:           field.Type,
-					Index:          i,
-					MustBeComplete: !allowCircular,
-				})
-			}
-		}
+				values[typ] = vptr.Elem()
+				return nil
+			},
+		},
+		Complete: state{
+			DependsOn: []task{{typ, false}},
+		},
+	}
+}
+
+// autoProvidePtr automatically provides a pointer type.
+// Unless always is set, the type must have provide-tagged fields or a PleaseProvide method.
+func autoProvidePtr(typ reflect.Type, defaultTag string, always bool) (initializer, error) {
+	elem := typ.Elem()
+	providedFields, err := findProvidedFields(elem, defaultTag)
+	if err != nil {
+		return initializer{}, err
+	}
+
+	initFn, ins, hasInitFn, err := findInitFn(typ)
+	if err != nil {
+		return initializer{}, err
+	}
 
-		initFn, hasInitFn := typ.MethodByName("PleaseProvide")
-		var ins []reflect.Type
+	if len(providedFields) == 0 && !hasInitFn && !always {
+		return initializer{}, errors.New(typ.String() + " can't be automatically provided")
+	}
+
+	deps := make([]task, 0, 1+len(providedFields)+len(ins))
+	deps = append(deps, task{typ, false})
+	deps = appendDeps(deps, providedFields, ins)
+
+	doFn := func(values map[reflect.Type]reflect.Value) error {
+		v := values[typ]
+		if v.IsNil() {
+			// Middleware skipped constructing it, so there's nothing to initialize.
+			return nil
+		}
+		if err := setProvidedFields(v.Elem(), providedFields, values); err != nil {
+			return err
+		}
 		if hasInitFn {
-			ins = make([]reflect.Type, initFn.Type.NumIn()-1)
-			for i := range ins {
-				ins[i] = initFn.Type.In(i + 1)
-			}
+			return callInitFn(initFn, v, ins, values)
+		}
+		return nil
+	}
 
-			nOut := initFn.Type.NumOut()
-			for i := 0; i < nOut; i++ {
-				if !isErrorType(initFn.Type.Out(i)) {
-					return initializer{}, errors.New(
-						typ.String() + ".PleaseProvide must only return errors (which must be interfaces), not " + initFn.Type.Out(i).String(),
-					)
-				}
+	return initializer{
+		Type: typ,
+		Partial: state{
+			Do: func(values map[reflect.Type]reflect.Value) error {
+				values[typ] = reflect.New(elem)
+				return nil
+			},
+		},
+		Complete: state{
+			DependsOn: deps,
+			Do:        doFn,
+		},
+	}, nil
+}
+
+func autoProvideValue(typ reflect.Type, defaultTag string) (initializer, error) {
+	var providedFields []providedField
+	if typ.Kind() == reflect.Struct {
+		var err error
+		providedFields, err = findProvidedFields(typ, defaultTag)
+		if err != nil {
+			return initializer{}, err
+		}
+	}
+
+	initFn, ins, hasInitFn, err := findInitFn(typ)
+	if err != nil {
+		return initializer{}, err
+	}
+
+	deps := appendDeps(make([]task, 0, len(providedFields)+len(ins)), providedFields, ins)
+	doFn := func(values map[reflect.Type]reflect.Value) error {
+		v := reflect.New(typ).Elem()
+		if err := setProvidedFields(v, providedFields, values); err != nil {
+			return err
+		}
+		if hasInitFn {
+			if err := callInitFn(initFn, v, ins, values); err != nil {
+				return err
 			}
 		}
 
-		if len(providedFields) == 0 && !hasInitFn {
-			return initializer{}, errors.New(typ.String() + " can't be automatically provided")
+		values[typ] = v
+		return nil
+	}
+
+	return initializer{
+		Type:     typ,
+		Partial:  state{DependsOn: deps, Do: doFn},
+		Complete: state{DependsOn: []task{{typ, false}}},
+	}, nil
+}
+
+// typeScope returns the scope a struct type (or pointer to one) belongs to (see OfScope),
+// from the provide-scope tag on any of its fields.
+func typeScope(typ reflect.Type) string {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return ""
+	}
+
+	N := typ.NumField()
+	for i := 0; i < N; i++ {
+		if scope, ok := typ.Field(i).Tag.Lookup("provide-scope"); ok {
+			return scope
 		}
+	}
+	return ""
+}
 
-		deps := make([]task, 0, 1+len(providedFields)+len(ins))
-		deps = append(deps, task{typ, false})
-		for _, field := range providedFields {
-			deps = append(deps, task{field.Type, field.MustBeComplete})
+// providedThroughPtr reports whether a non-pointer type is automatically provided
+// by automatically providing a pointer to it, and dereferencing that.
+func providedThroughPtr(typ reflect.Type) bool {
+	if _, ok := typ.MethodByName("PleaseProvide"); ok {
+		// The value receiver can't be modified by PleaseProvide,
+		// so there's no reason to go through a pointer.
+		return false
+	}
+	if _, ok := reflect.PtrTo(typ).MethodByName("PleaseProvide"); ok || typ.Kind() != reflect.Struct {
+		return true
+	}
+
+	// Without PleaseProvide, the fields of a struct can be filled in directly.
+	N := typ.NumField()
+	for i := 0; i < N; i++ {
+		if _, ok := typ.Field(i).Tag.Lookup("provide"); ok {
+			return false
 		}
-		for _, in := range ins {
-			deps = append(deps, task{in, true})
+	}
+	return true
+}
+
+func findProvidedFields(elem reflect.Type, defaultTag string) ([]providedField, error) {
+	if elem.Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	var providedFields []providedField
+	tags := make(map[reflect.Type]string)
+	N := elem.NumField()
+	for i := 0; i < N; i++ {
+		field := elem.Field(i)
+		tag, ok := field.Tag.Lookup("provide")
+		if !ok {
+			continue
 		}
 
-		initFnVal := initFn.Func
-		doFn := func(values map[reflect.Type]reflect.Value) error {
-			v := values[typ]
+		dep := field.Type
+		if tag == "ptr" {
+			dep = reflect.PtrTo(dep)
+		}
+		if other, ok := tags[dep]; ok {
+			both := "both provided"
+			if other == tag {
+				both = "both tagged provide:\"" + tag + "\""
+			}
+			return nil, errors.New(
+				reflect.PtrTo(elem).String() + " has two fields of the same type " + dep.String() + " " + both +
+					" — use distinct types (type-aliases) to distinguish them",
+			)
+		}
+		tags[dep] = tag
 
-			if len(providedFields) > 0 {
-				elem := v.Elem()
-				for _, field := range providedFields {
-					elem.Field(field.Index).Set(values[field.Type])
-				}
+		if tag == "ptr" {
+			if field.Type.Kind() == reflect.Ptr {
+				return nil, errors.New(
+					"provide:\"ptr\" is for non-pointer fields, but " + elem.String() + "." + field.Name + " is " + field.Type.String(),
+				)
 			}
 
-			if hasInitFn {
-				inputs := make([]reflect.Value, len(ins)+1)
-				inputs[0] = v
-				for i, in := range ins {
-					inputs[i+1] = values[in]
-				}
-				outputs := initFnVal.Call(inputs)
-				for _, out := range outputs {
-					if !out.IsNil() {
-						return out.Interface().(error)
-					}
-				}
+			// Provide a pointer, and dereference it into the field.
+			providedFields = append(providedFields, providedField{
+				Type:           reflect.PtrTo(field.Type),
+				Index:          i,
+				MustBeComplete: true,
+				Deref:          true,
+			})
+			continue
+		}
+
+		allowCircular := (tag == "circular")
+		if !allowCircular {
+			if tag != "" && tag != defaultTag {
+				return nil, errors.New(
+					"unrecognized provide tag " + tag + " in " + elem.String(),
+				)
 			}
+		} else {
+			if !IsReferenceType(field.Type) {
+				return nil, errors.New(
+					"only reference types (pointer, map, chan) can be circularly provided to " + elem.String() + ", not " + field.Type.String(),
+				)
+			}
+		}
 
-			return nil
+		providedFields = append(providedFields, providedField{
+			Type:           field.Type,
+			Index:          i,
+			MustBeComplete: !allowCircular,
+		})
+	}
+	return providedFields, nil
+}
+
+func findInitFn(typ reflect.Type) (reflect.Method, []reflect.Type, bool, error) {
+	initFn, hasInitFn := typ.MethodByName("PleaseProvide")
+	if !hasInitFn {
+		return initFn, nil, false, nil
+	}
+
+	ins := make([]reflect.Type, initFn.Type.NumIn()-1)
+	for i := range ins {
+		ins[i] = initFn.Type.In(i + 1)
+	}
+
+	nOut := initFn.Type.NumOut()
+	for i := 0; i < nOut; i++ {
+		if !IsErrorType(initFn.Type.Out(i)) {
+			return initFn, nil, false, errors.New(
+				typ.String() + ".PleaseProvide must only return errors (which must be interfaces), not " + initFn.Type.Out(i).String(),
+			)
 		}
+	}
+	return initFn, ins, true, nil
+}
 
-		return initializer{
-			Type: typ,
-			Partial: state{
-				Do: func(values map[reflect.Type]reflect.Value) error {
-					values[typ] = reflect.New(elem)
-					return nil
-				},
-			},
-			Complete: state{
-				DependsOn: deps,
-				Do:        doFn,
-			},
-		}, nil
+func appendDeps(deps []task, providedFields []providedField, ins []reflect.Type) []task {
+	for _, field := range providedFields {
+		deps = append(deps, task{field.Type, field.MustBeComplete})
+	}
+	for _, in := range ins {
+		deps = append(deps, task{in, true})
+	}
+	return deps
+}
 
-	default:
-		ptrTo := reflect.PtrTo(typ)
-		return initializer{
-			Type: typ,
-			Partial: state{
-				DependsOn: []task{{ptrTo, true}},
-				Do: func(values map[reflect.Type]reflect.Value) error {
-					vptr := values[ptrTo]
-					if vptr.IsNil() {
-						return errors.New("can't use nil pointer to automatically provide value for " + typ.String())
-					}
-
-					values[typ] = vptr.Elem()
-					return nil
-				},
-			},
-			Complete: state{
-				DependsOn: []task{{typ, false}},
-			},
-		}, nil
+func setProvidedFields(elem reflect.Value, providedFields []providedField, values map[reflect.Type]reflect.Value) error {
+	for _, field := range providedFields {
+		v := values[field.Type]
+		if field.Deref {
+			if v.IsNil() {
+				return errors.New(
+					"can't use nil " + field.Type.String() + " for provide:\"ptr\" field " + elem.Type().String() + "." + elem.Type().Field(field.Index).Name,
+				)
+			}
+			v = v.Elem()
+		}
+		elem.Field(field.Index).Set(v)
+	}
+	return nil
+}
+
+func callInitFn(initFn reflect.Method, v reflect.Value, ins []reflect.Type, values map[reflect.Type]reflect.Value) error {
+	inputs := make([]reflect.Value, len(ins)+1)
+	inputs[0] = v
+	for i, in := range ins {
+		inputs[i+1] = values[in]
+	}
+	outputs := initFn.Func.Call(inputs)
+	for _, out := range outputs {
+		if !out.IsNil() {
+			return out.Interface().(error)
+		}
 	}
+	return nil
 }
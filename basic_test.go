@@ -1,10 +1,20 @@
 package provide_test
 
 import (
+	"context"
+	"errors"
 	"github.com/MatthewValentine/provide"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func assert(t *testing.T, condition bool, args ...interface{}) {
@@ -62,3 +72,1777 @@ func (star *Patrick) PleaseProvide(kp KrabbyPatty) {
 }
 
 type KrabbyPatty string
+
+func TestSealRules(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+	assert(t, !p.IsSealedRules())
+
+	p.SealRules()
+	assert(t, p.IsSealedRules())
+
+	err = p.AddRule(func(sp *Spongebob) InPineapple {
+		return sp
+	})
+	assert(t, err != nil)
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	assert(t, sp.Patty == "jabberwocky", sp)
+}
+
+func TestNewProviderErrors(t *testing.T) {
+	patty := func() KrabbyPatty { return "jabberwocky" }
+	pineapple := func(sp *Spongebob) InPineapple { return sp }
+	_, err := provide.NewProvider(patty, patty, pineapple, pineapple, "not a rule")
+	assert(t, err != nil)
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	assert(t, ok, err)
+	assert(t, len(joined.Unwrap()) == 3, err)
+}
+
+func TestProvideInto(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	var got KrabbyPatty
+	err = p.ProvideInto(func(sp *Spongebob, star *Patrick) error {
+		got = sp.Patty
+		if star.Patty != sp.Patty {
+			return errors.New("mismatched patties")
+		}
+		return nil
+	})
+	assert(t, err == nil, err)
+	assert(t, got == "jabberwocky", got)
+
+	holder := struct {
+		Fn func(KrabbyPatty) error
+	}{
+		Fn: func(kp KrabbyPatty) error {
+			return errors.New(string(kp))
+		},
+	}
+	err = p.ProvideInto(&holder.Fn)
+	assert(t, err != nil && err.Error() == "jabberwocky", err)
+}
+
+func TestMiddleware(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	var calls []string
+	for _, name := range []string{"outer", "inner"} {
+		name := name
+		p.AddMiddleware(func(typ reflect.Type, next func() error) error {
+			calls = append(calls, name+" "+typ.String())
+			return next()
+		})
+	}
+
+	var kp KrabbyPatty
+	err = p.Provide(&kp)
+	assert(t, err == nil, err)
+	assert(t, kp == "jabberwocky", kp)
+	assert(t, len(calls) == 2, calls)
+	assert(t, calls[0] == "outer provide_test.KrabbyPatty", calls)
+	assert(t, calls[1] == "inner provide_test.KrabbyPatty", calls)
+
+	p.AddMiddleware(func(typ reflect.Type, next func() error) error {
+		return errors.New("refusing to construct " + typ.String())
+	})
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err != nil)
+}
+
+func TestAddInterceptor(t *testing.T) {
+	calls := 0
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		calls++
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	p.AddInterceptor(func(typ reflect.Type, next func() error) error {
+		if typ == reflect.TypeOf(KrabbyPatty("")) {
+			return nil
+		}
+		return next()
+	})
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	assert(t, calls == 0, "the rule should have been skipped")
+	assert(t, sp.Patty == "", sp)
+}
+
+func TestSelfDependentRule(t *testing.T) {
+	_, err := provide.NewProvider(func(sp *Spongebob) *Spongebob {
+		return sp
+	})
+	assert(t, err != nil)
+}
+
+func TestProvideValueReceiver(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	var squidward Squidward
+	err = p.Provide(&squidward)
+	assert(t, err == nil, err)
+	assert(t, squidward.Patty == "jabberwocky", squidward)
+
+	var grumpy *Squidward
+	err = p.Provide(&grumpy)
+	assert(t, err == nil, err)
+	assert(t, grumpy.Patty == "jabberwocky", grumpy)
+}
+
+type Squidward struct {
+	Patty KrabbyPatty `provide:""`
+}
+
+func (squid Squidward) PleaseProvide(kp KrabbyPatty) error {
+	if squid.Patty != kp {
+		return errors.New("Squidward wasn't served a patty")
+	}
+	return nil
+}
+
+func TestInterfaceOutputWarning(t *testing.T) {
+	p := &provide.Provider{}
+	var warnings []string
+	p.OnWarning(func(warning string) {
+		warnings = append(warnings, warning)
+	})
+
+	err := p.AddRule(func(sp Spongebob) InPineapple {
+		return sp
+	})
+	assert(t, err == nil, err)
+	assert(t, len(warnings) == 0, warnings)
+
+	err = p.AddRule(func(kp KrabbyPatty) UnderSea {
+		return Patrick{Patty: kp}
+	})
+	assert(t, err == nil, err)
+	assert(t, len(warnings) == 1, warnings)
+}
+
+func TestTrace(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	stop := p.StartTrace()
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	entries := stop()
+
+	assert(t, len(entries) == 3, entries)
+	assert(t, entries[0].Type == reflect.TypeOf(sp) && entries[0].Phase == "construct", entries[0])
+	assert(t, entries[1].Type == reflect.TypeOf(sp.Patty) && entries[1].Phase == "construct", entries[1])
+	assert(t, entries[2].Type == reflect.TypeOf(sp) && entries[2].Phase == "initialize", entries[2])
+	assert(t, len(entries[2].Deps) == 1 && entries[2].Deps[0] == reflect.TypeOf(sp.Patty), entries[2])
+}
+
+type recordingTracer struct {
+	spans []string
+	ended int
+}
+
+type spanKey struct{}
+
+func (r *recordingTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, provide.Span) {
+	span := name + " " + attributes["provide.rule"] + " " + attributes["provide.phase"]
+	if parent, ok := ctx.Value(spanKey{}).(string); ok {
+		span += " in " + parent
+	}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, spanKey{}, name), recordingSpan{r}
+}
+
+type recordingSpan struct {
+	r *recordingTracer
+}
+
+func (s recordingSpan) End(err error) {
+	s.r.ended++
+}
+
+func TestWithTracer(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	tracer := &recordingTracer{}
+	traced := p.WithTracer(tracer)
+	var sp *Spongebob
+	err = traced.Provide(&sp)
+	assert(t, err == nil, err)
+	assert(t, len(tracer.spans) == 3 && tracer.ended == 3, tracer.spans, tracer.ended)
+	assert(t, tracer.spans[0] == "*provide_test.Spongebob auto initialize", tracer.spans[0])
+	assert(t, tracer.spans[1] == "*provide_test.Spongebob auto construct in *provide_test.Spongebob", tracer.spans[1])
+	assert(t, tracer.spans[2] == "provide_test.KrabbyPatty custom construct in *provide_test.Spongebob", tracer.spans[2])
+
+	// The original Provider isn't traced.
+	err = p.Provide(&sp)
+	assert(t, err == nil && len(tracer.spans) == 3, tracer.spans, err)
+
+	// Spans for what a rule constructs while it runs are nested under its span.
+	tracer = &recordingTracer{}
+	p, err = provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+	err = p.AddWeak(func(kp KrabbyPatty) *Order { return &Order{Patty: kp} })
+	assert(t, err == nil, err)
+	var order *Order
+	err = p.WithTracer(tracer).Provide(&order)
+	assert(t, err == nil && order.Patty == "jabberwocky", order, err)
+	assert(t, len(tracer.spans) > 1 && tracer.ended == len(tracer.spans), tracer.spans, tracer.ended)
+	assert(t, tracer.spans[len(tracer.spans)-1] == "provide_test.KrabbyPatty custom construct in *provide_test.Order", tracer.spans)
+}
+
+func TestValidateType(t *testing.T) {
+	err := provide.ValidateType(reflect.TypeOf(Spongebob{}))
+	assert(t, err == nil, err)
+
+	err = provide.ValidateType(reflect.TypeOf(&Patrick{}))
+	assert(t, err == nil, err)
+
+	err = provide.ValidateType(reflect.TypeOf(BadCircular{}))
+	assert(t, err != nil)
+
+	err = provide.ValidateType(reflect.TypeOf(&BadCircular{}))
+	assert(t, err != nil)
+}
+
+type BadCircular struct {
+	Patty KrabbyPatty `provide:"circular"`
+}
+
+func TestReplay(t *testing.T) {
+	patties := []KrabbyPatty{"jabberwocky", "bandersnatch"}
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		kp := patties[0]
+		patties = patties[1:]
+		return kp
+	})
+	assert(t, err == nil, err)
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	assert(t, sp.Patty == "jabberwocky", sp)
+
+	err = p.Replay()
+	assert(t, err == nil, err)
+
+	var replayed *Spongebob
+	err = p.Provide(&replayed)
+	assert(t, err == nil, err)
+	assert(t, replayed != sp, "values should have been reconstructed")
+	assert(t, replayed.Patty == "bandersnatch", replayed)
+
+	patties = []KrabbyPatty{"jabberwocky", "bandersnatch"}
+	p, err = provide.NewProvider(func() KrabbyPatty {
+		kp := patties[0]
+		patties = patties[1:]
+		return kp
+	})
+	assert(t, err == nil, err)
+	var calls []string
+	err = p.AddPostConstruct((**Spongebob)(nil), func(sp *Spongebob) {
+		calls = append(calls, string(sp.Patty))
+	})
+	assert(t, err == nil, err)
+	p.SetValueTransformer(func(t reflect.Type, v reflect.Value) reflect.Value {
+		if t == reflect.TypeOf(KrabbyPatty("")) {
+			return reflect.ValueOf(KrabbyPatty("fresh " + v.String()))
+		}
+		return v
+	})
+
+	err = p.Provide(&sp)
+	assert(t, err == nil && sp.Patty == "fresh jabberwocky", sp, err)
+	err = p.Replay()
+	assert(t, err == nil, err)
+	err = p.Provide(&replayed)
+	assert(t, err == nil && replayed.Patty == "fresh bandersnatch", "replayed values are transformed", replayed, err)
+	assert(t, len(calls) == 2 && calls[1] == "fresh bandersnatch", "post-construct functions are called again", calls)
+}
+
+func TestApplyTo(t *testing.T) {
+	module, err := provide.NewProvider(
+		func() KrabbyPatty {
+			return "jabberwocky"
+		},
+		func(sp *Spongebob) InPineapple {
+			return sp
+		},
+	)
+	assert(t, err == nil, err)
+
+	app, err := provide.NewProvider(func(star *Patrick) UnderSea {
+		return star
+	})
+	assert(t, err == nil, err)
+
+	err = module.ApplyTo(app)
+	assert(t, err == nil, err)
+
+	var ip InPineapple
+	var us UnderSea
+	err = app.Provide(&ip, &us)
+	assert(t, err == nil, err)
+	assert(t, ip.(*Spongebob).Patty == "jabberwocky", ip)
+	assert(t, us.(*Patrick).Patty == "jabberwocky", us)
+
+	err = module.ApplyTo(app)
+	assert(t, err != nil, "rules should conflict")
+}
+
+func TestLen(t *testing.T) {
+	p, err := provide.NewProvider(
+		func() (KrabbyPatty, error) {
+			return "jabberwocky", nil
+		},
+		func(sp *Spongebob, star *Patrick) (InPineapple, UnderSea) {
+			return sp, star
+		},
+	)
+	assert(t, err == nil, err)
+	assert(t, p.Len() == 3, p.Len())
+	assert(t, p.ValueCount() == 0, p.ValueCount())
+
+	var ip InPineapple
+	err = p.Provide(&ip)
+	assert(t, err == nil, err)
+	assert(t, p.Len() == 3, p.Len())
+	assert(t, p.ValueCount() == 5, p.ValueCount())
+}
+
+func TestMarshalDependencyGraph(t *testing.T) {
+	p, err := provide.NewProvider(
+		func() (KrabbyPatty, error) {
+			return "jabberwocky", nil
+		},
+		func(star *Patrick, sp *Spongebob) (InPineapple, UnderSea) {
+			return sp, star
+		},
+	)
+	assert(t, err == nil, err)
+	err = p.AddPrototype(func(kp KrabbyPatty) func() *Ticket {
+		return func() *Ticket { return &Ticket{} }
+	})
+	assert(t, err == nil, err)
+
+	graph, err := p.MarshalDependencyGraph()
+	assert(t, err == nil, err)
+
+	const expected = `{` +
+		`"*provide_test.Ticket":["func() *provide_test.Ticket"],` +
+		`"func() *provide_test.Ticket":["provide_test.KrabbyPatty"],` +
+		`"provide_test.InPineapple":["*provide_test.Patrick","*provide_test.Spongebob"],` +
+		`"provide_test.KrabbyPatty":[],` +
+		`"provide_test.UnderSea":["*provide_test.Patrick","*provide_test.Spongebob"]` +
+		`}`
+	assert(t, string(graph) == expected, string(graph))
+}
+
+func TestProvideGroup(t *testing.T) {
+	p, err := provide.NewProvider(
+		func() KrabbyPatty {
+			return "jabberwocky"
+		},
+		func(sp *Spongebob) InPineapple {
+			return sp
+		},
+	)
+	assert(t, err == nil, err)
+
+	var group *BikiniBottom
+	err = p.ProvideGroup(&group)
+	assert(t, err == nil, err)
+	assert(t, group.Home.(*Spongebob).Patty == "jabberwocky", group)
+
+	var same *BikiniBottom
+	err = p.Provide(&same)
+	assert(t, err == nil, err)
+	assert(t, same == group, same, group)
+
+	var gary *Gary
+	err = p.ProvideGroup(&gary)
+	assert(t, err == nil, err)
+	assert(t, gary != nil)
+
+	var ip InPineapple
+	err = p.ProvideGroup(&ip)
+	assert(t, err != nil)
+}
+
+type BikiniBottom struct {
+	Home InPineapple `provide:""`
+}
+
+func TestSetDefaultTag(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	var larry *Larry
+	err = p.Provide(&larry)
+	assert(t, err != nil, "inject shouldn't be recognized yet")
+
+	p, err = provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+	p.SetDefaultTag("inject")
+
+	err = p.Provide(&larry)
+	assert(t, err == nil, err)
+	assert(t, larry.Patty == "jabberwocky" && larry.Sponge.Patty == "jabberwocky", larry)
+
+	// Types are validated with the default tag too.
+	p, err = provide.NewProvider(provide.WithStrictMode())
+	assert(t, err == nil, err)
+	p.SetDefaultTag("inject")
+	err = p.RegisterType(reflect.TypeOf(larry))
+	assert(t, err == nil, err)
+	err = p.AddRule(func(larry *Larry) Squidward { return Squidward{} })
+	assert(t, err == nil, err)
+}
+
+type Larry struct {
+	Patty  KrabbyPatty `provide:"inject"`
+	Sponge *Spongebob  `provide:""`
+}
+
+func TestCheckTypes(t *testing.T) {
+	patty := func() KrabbyPatty { return "jabberwocky" }
+	pineapple := func(sp *Spongebob) InPineapple { return sp }
+
+	err := provide.CheckTypes(patty, pineapple)
+	assert(t, err == nil, err)
+
+	err = provide.CheckTypes(patty, pineapple, patty, 5, func(kp KrabbyPatty) KrabbyPatty { return kp })
+	assert(t, err != nil)
+	joined, ok := err.(interface{ Unwrap() []error })
+	assert(t, ok, err)
+	assert(t, len(joined.Unwrap()) == 3, err)
+}
+
+func TestLazy(t *testing.T) {
+	calls := 0
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		calls++
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	lazy := provide.Lazy[*Spongebob](p)
+	assert(t, calls == 0, calls)
+
+	sp, err := lazy.Get()
+	assert(t, err == nil, err)
+	assert(t, sp.Patty == "jabberwocky", sp)
+
+	same, err := lazy.Get()
+	assert(t, err == nil, err)
+	assert(t, same == sp, same, sp)
+	assert(t, calls == 1, calls)
+}
+
+func TestProvideConcreteError(t *testing.T) {
+	p, err := provide.NewProvider(func() *Overcooked {
+		return &Overcooked{}
+	})
+	assert(t, err == nil, err)
+
+	var overcooked *Overcooked
+	err = p.Provide(&overcooked)
+	assert(t, err != nil)
+}
+
+type Overcooked struct{}
+
+func (*Overcooked) Error() string { return "overcooked" }
+
+func TestStrictMode(t *testing.T) {
+	twoPatties := func(a, b KrabbyPatty) *Order {
+		return &Order{Patty: a + b}
+	}
+	pineapple := func(kp KrabbyPatty) InPineapple {
+		return Spongebob{Patty: kp}
+	}
+	badCircular := func(bad *BadCircular) *Order {
+		return &Order{}
+	}
+
+	for _, rule := range []interface{}{twoPatties, pineapple, badCircular} {
+		_, err := provide.NewProvider(rule)
+		assert(t, err == nil, err)
+
+		_, err = provide.NewProvider(provide.WithStrictMode(), rule)
+		assert(t, err != nil)
+	}
+}
+
+func TestWhenAll(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.WhenAll((**Spongebob)(nil)).Provide(&Order{})
+	assert(t, err != nil, "KrabbyPatty has no rule")
+
+	err = p.AddRule(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	var sp *Spongebob
+	err = p.WhenAll((**Spongebob)(nil), (**Patrick)(nil)).Provide(&sp)
+	assert(t, err == nil, err)
+	assert(t, sp.Patty == "jabberwocky", sp)
+
+	err = p.WhenAll((*Cycle1)(nil)).Provide(&sp)
+	assert(t, err != nil, "Cycle1 has a cycle")
+}
+
+type Cycle1 struct {
+	Two *Cycle2 `provide:""`
+}
+
+type Cycle2 struct {
+	One *Cycle1 `provide:""`
+}
+
+func TestFindCycles(t *testing.T) {
+	p, err := provide.NewProvider(
+		func() KrabbyPatty {
+			return "jabberwocky"
+		},
+		func(one *Cycle1) InPineapple {
+			return nil
+		},
+	)
+	assert(t, err == nil, err)
+
+	cycles, err := p.FindCycles((*InPineapple)(nil), (**Spongebob)(nil), (**Cycle2)(nil), (**Fish)(nil))
+	assert(t, err == nil, err)
+	assert(t, len(cycles) == 1, cycles)
+	assert(t, len(cycles[0]) == 2, cycles)
+	assert(t, cycles[0][0] == reflect.TypeOf(&Cycle2{}) && cycles[0][1] == reflect.TypeOf(&Cycle1{}), cycles)
+
+	err = p.AddRule(func(two *Cycle2) UnderSea {
+		return nil
+	})
+	assert(t, err == nil, err)
+	cycles, err = p.FindCycles((*UnderSea)(nil), (**Cycle3)(nil))
+	assert(t, err == nil, err)
+	assert(t, len(cycles) == 2, cycles)
+}
+
+type Cycle3 struct {
+	Two  *Cycle2 `provide:""`
+	Four *Cycle4 `provide:""`
+}
+
+type Cycle4 struct {
+	Three *Cycle3 `provide:""`
+}
+
+type Fish struct {
+	Fish *Fish `provide:"circular"`
+}
+
+func TestCancelInitialization(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		close(started)
+		<-cancelled
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	go func() {
+		<-started
+		p.CancelInitialization()
+		close(cancelled)
+	}()
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err != nil, "Provide should have been cancelled")
+	assert(t, sp == nil, sp)
+
+	// A call with nothing left to construct after the cancellation isn't cancelled,
+	// and neither is the next one.
+	started = make(chan struct{})
+	cancelled = make(chan struct{})
+	p, err = provide.NewProvider(func() KrabbyPatty {
+		close(started)
+		<-cancelled
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+	go func() {
+		<-started
+		p.CancelInitialization()
+		close(cancelled)
+	}()
+
+	var kp KrabbyPatty
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "jabberwocky", kp, err)
+	err = p.Provide(&sp)
+	assert(t, err == nil && sp.Patty == kp, sp, err)
+}
+
+func TestBindFunc(t *testing.T) {
+	p := &provide.Provider{}
+	serve := func(w http.ResponseWriter, r *http.Request) {}
+
+	err := p.BindFunc((*http.Handler)(nil), serve)
+	assert(t, err != nil, "plain functions have no methods")
+
+	err = p.BindFunc((*http.Handler)(nil), func(r *http.Request) {})
+	assert(t, err != nil, "the signature doesn't match")
+
+	err = p.BindFunc((*InPineapple)(nil), http.HandlerFunc(serve))
+	assert(t, err != nil, "the signature doesn't match")
+
+	err = p.BindFunc((*http.Handler)(nil), http.HandlerFunc(serve))
+	assert(t, err == nil, err)
+
+	var handler http.Handler
+	err = p.Provide(&handler)
+	assert(t, err == nil, err)
+	_, ok := handler.(http.HandlerFunc)
+	assert(t, ok, handler)
+}
+
+func TestPostConstruct(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	var calls []string
+	err = p.AddPostConstruct((**Spongebob)(nil), func(sp *Spongebob) {
+		calls = append(calls, "first "+string(sp.Patty))
+	})
+	assert(t, err == nil, err)
+	err = p.AddPostConstruct((**Spongebob)(nil), func(sp *Spongebob) error {
+		calls = append(calls, "second")
+		return nil
+	})
+	assert(t, err == nil, err)
+	err = p.AddPostConstruct((**Spongebob)(nil), func(sp Spongebob) {})
+	assert(t, err != nil, "wrong argument type")
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	assert(t, len(calls) == 2 && calls[0] == "first jabberwocky" && calls[1] == "second", calls)
+
+	err = p.AddPostConstruct((**Patrick)(nil), func(star *Patrick) error {
+		return errors.New("burnt")
+	})
+	assert(t, err == nil, err)
+
+	var star *Patrick
+	err = p.Provide(&star)
+	assert(t, err != nil && err.Error() == "burnt", err)
+}
+
+func TestCycleError(t *testing.T) {
+	p := &provide.Provider{}
+	var one *Cycle1
+	err := p.Provide(&one)
+	assert(t, err != nil)
+	const expected = "cycle: *provide_test.Cycle1 --> *provide_test.Cycle2 --> *provide_test.Cycle1. " +
+		"Possible fix: add `provide:\"circular\"` to the Cycle1.Two field (it is a pointer type, so it can be a circular dependency)"
+	assert(t, err.Error() == expected, err)
+
+	p, err = provide.NewProvider(
+		func(us UnderSea) KrabbyPatty {
+			return "jabberwocky"
+		},
+		func(sp Spongebob) UnderSea {
+			return sp
+		},
+	)
+	assert(t, err == nil, err)
+	var kp KrabbyPatty
+	err = p.Provide(&kp)
+	assert(t, err != nil)
+	assert(t, !strings.Contains(err.Error(), "Possible fix"), err)
+	assert(t, strings.HasPrefix(err.Error(), "cycle: provide_test.KrabbyPatty --> provide_test.UnderSea --> "), err)
+}
+
+func TestRegisterType(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.RegisterType(reflect.TypeOf(&Spongebob{}))
+	assert(t, err == nil, err)
+	err = p.RegisterType(reflect.TypeOf(Patrick{}))
+	assert(t, err == nil, err)
+
+	err = p.RegisterType(reflect.TypeOf(&BadCircular{}))
+	assert(t, err != nil)
+	err = p.RegisterType(reflect.TypeOf(Order{}))
+	assert(t, err != nil, "Order can't be automatically provided")
+	err = p.RegisterType(reflect.TypeOf((*InPineapple)(nil)).Elem())
+	assert(t, err != nil, "interfaces can't be automatically provided")
+}
+
+func TestAddChain(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddChain(
+		func() KrabbyPatty {
+			return "jabberwocky"
+		},
+		func(kp KrabbyPatty) *Order {
+			return &Order{Patty: kp}
+		},
+		func(order *Order, kp KrabbyPatty) InPineapple {
+			return Spongebob{Patty: order.Patty}
+		},
+	)
+	assert(t, err != nil && strings.HasPrefix(err.Error(), "chain broken at position 3: "), err)
+
+	err = p.AddChain(
+		func() KrabbyPatty {
+			return "jabberwocky"
+		},
+		func(kp KrabbyPatty) (*Order, error) {
+			return &Order{Patty: kp}, nil
+		},
+		func(order *Order) InPineapple {
+			return Spongebob{Patty: order.Patty}
+		},
+	)
+	assert(t, err == nil, err)
+
+	var ip InPineapple
+	err = p.Provide(&ip)
+	assert(t, err == nil, err)
+	assert(t, ip.(Spongebob).Patty == "jabberwocky", ip)
+
+	// A rule that conflicts with one the Provider already has keeps the whole chain out.
+	n := p.Len()
+	err = p.AddChain(
+		func() UnderSea { return Patrick{} },
+		func(us UnderSea) *Order { return &Order{} },
+	)
+	assert(t, err != nil, "*Order is already provided")
+	assert(t, p.Len() == n, p.Len(), n)
+	err = p.AddRule(func() UnderSea { return Patrick{} })
+	assert(t, err == nil, err)
+}
+
+func TestGetOrZero(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	assert(t, provide.GetOrZero[*Spongebob](p) == nil)
+	assert(t, provide.GetOrZero[KrabbyPatty](p) == "")
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	assert(t, provide.GetOrZero[*Spongebob](p) == sp)
+	assert(t, provide.GetOrZero[KrabbyPatty](p) == "jabberwocky")
+	assert(t, provide.GetOrZero[InPineapple](p) == nil)
+}
+
+func TestDependencyCount(t *testing.T) {
+	p, err := provide.NewProvider(
+		func() KrabbyPatty {
+			return "jabberwocky"
+		},
+		func(sp *Spongebob, star *Patrick) UnderSea {
+			return star
+		},
+	)
+	assert(t, err == nil, err)
+
+	for i := 0; i < 2; i++ {
+		direct, transitive, err := p.DependencyCount((*UnderSea)(nil))
+		assert(t, err == nil, err)
+		assert(t, direct == 2 && transitive == 3, direct, transitive)
+
+		var us UnderSea
+		err = p.Provide(&us)
+		assert(t, err == nil, err)
+	}
+
+	_, _, err = p.DependencyCount((*InPineapple)(nil))
+	assert(t, err != nil, "InPineapple can't be provided")
+}
+
+func TestRunParallel(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddRule(func() KrabbyPatty { return "pickles" })
+	assert(t, err == nil, err)
+
+	var seen []string
+	sawPatty := make(chan bool, 1)
+	err = p.RunParallel(
+		func(sp *Spongebob) { seen = append(seen, string(sp.Patty)) },
+		func(sp *Spongebob, s Squidward) { seen = append(seen, "squidward") },
+		func(kp KrabbyPatty) error {
+			sawPatty <- kp == "pickles"
+			return errors.New("patrick forgot")
+		},
+	)
+	assert(t, err != nil && strings.Contains(err.Error(), "patrick forgot"), err)
+	assert(t, len(seen) == 2 && seen[1] == "squidward", seen)
+	assert(t, <-sawPatty, "Patrick should have been provided with a patty")
+
+	err = p.RunParallel(42)
+	assert(t, err != nil, "42 isn't a function")
+}
+
+func TestExpandMissingPlugin(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.Expand("/nonexistent/rules.so")
+	assert(t, err != nil, "the plugin doesn't exist")
+}
+
+func TestSummarize(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddRule(func() KrabbyPatty { return "pickles" })
+	assert(t, err == nil, err)
+
+	var b strings.Builder
+	err = p.Summarize(&b, (*Spongebob)(nil))
+	assert(t, err == nil, err)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert(t, len(lines) == 2, b.String())
+	assert(t, strings.HasPrefix(lines[0], "1. provide_test.KrabbyPatty: rule "), lines[0])
+	assert(t, strings.Contains(lines[0], "basic_test.go:"), lines[0])
+	assert(t, lines[1] == "2. provide_test.Spongebob: automatically", lines[1])
+
+	b.Reset()
+	err = p.Summarize(&b, (*Squidward)(nil), (*Krusty)(nil))
+	assert(t, err == nil, err)
+	assert(t, strings.HasSuffix(b.String(), "provide_test.Krusty: automatically, from *provide_test.Krusty\n"), b.String())
+
+	var sp Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+
+	b.Reset()
+	err = p.Summarize(&b, (*Spongebob)(nil))
+	assert(t, err == nil, err)
+	assert(t, b.String() == "1. provide_test.Spongebob: already provided\n", b.String())
+
+	err = p.Summarize(&b, (*Cycle1)(nil))
+	assert(t, err != nil, "there's a cycle")
+}
+
+type Krusty struct {
+	Cook   *Spongebob  `provide:""`
+	Patty  KrabbyPatty `provide:""`
+	Friend *Patrick
+}
+
+func (k *Krusty) PleaseProvide(s Squidward, pt *Patrick) {
+	k.Friend = pt
+}
+
+func TestInitializationOrder(t *testing.T) {
+	var first []reflect.Type
+	for run := 0; run < 100; run++ {
+		p, err := provide.NewProvider(
+			func() KrabbyPatty { return "jabberwocky" },
+			func() *Patrick { return &Patrick{} },
+		)
+		assert(t, err == nil, err)
+
+		stop := p.StartTrace()
+		var k *Krusty
+		err = p.Provide(&k)
+		assert(t, err == nil, err)
+
+		var order []reflect.Type
+		for _, entry := range stop() {
+			order = append(order, entry.Type)
+		}
+		if first == nil {
+			first = order
+		}
+		assert(t, reflect.DeepEqual(order, first), run, order, first)
+	}
+
+	// Dependencies are constructed in the order they're declared:
+	// provide-tagged fields first, then the arguments to PleaseProvide.
+	assert(t, first[0] == reflect.TypeOf((*Krusty)(nil)), first)
+	assert(t, first[1] == reflect.TypeOf((*Spongebob)(nil)), first)
+	assert(t, first[len(first)-2] == reflect.TypeOf((*Patrick)(nil)), first)
+}
+
+type Bubble struct {
+	Friend Patrick `provide:"ptr"`
+}
+
+type BadBubble struct {
+	Friend *Patrick `provide:"ptr"`
+}
+
+func TestPtrTag(t *testing.T) {
+	p, err := provide.NewProvider(func() *Patrick { return &Patrick{Patty: "jabberwocky"} })
+	assert(t, err == nil, err)
+
+	var b *Bubble
+	err = p.Provide(&b)
+	assert(t, err == nil, err)
+	assert(t, b.Friend.Patty == "jabberwocky", b)
+
+	var bad *BadBubble
+	err = p.Provide(&bad)
+	assert(t, err != nil, "provide:\"ptr\" needs a non-pointer field")
+
+	p, err = provide.NewProvider(func() *Patrick { return nil })
+	assert(t, err == nil, err)
+	err = p.Provide(&b)
+	assert(t, err != nil, "the *Patrick is nil")
+}
+
+func TestBindSlice(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	err = p.BindSlice((*[]UnderSea)(nil),
+		func(kp KrabbyPatty) UnderSea { return Patrick{Patty: kp} },
+		func(sp *Spongebob) (*Spongebob, error) { return sp, nil },
+		func(kp KrabbyPatty) Patrick { return Patrick{Patty: kp + "!"} },
+	)
+	assert(t, err == nil, err)
+
+	var all []UnderSea
+	err = p.Provide(&all)
+	assert(t, err == nil, err)
+	assert(t, len(all) == 3, all)
+	assert(t, all[0].(Patrick).Patty == "jabberwocky", all)
+	assert(t, all[1].(*Spongebob).Patty == "jabberwocky", all)
+	assert(t, all[2].(Patrick).Patty == "jabberwocky!", all)
+
+	err = p.BindSlice((*[]InPineapple)(nil), func() Patrick { return Patrick{} })
+	assert(t, err != nil, "Patrick isn't InPineapple")
+
+	p = &provide.Provider{}
+	err = p.BindSlice((*[]UnderSea)(nil), func() (UnderSea, error) { return nil, errors.New("sunk") })
+	assert(t, err == nil, err)
+	err = p.Provide(&all)
+	assert(t, err != nil && err.Error() == "sunk", err)
+}
+
+func TestLastError(t *testing.T) {
+	p := &provide.Provider{}
+	assert(t, p.LastError() == nil, p.LastError())
+
+	var sp *Spongebob
+	err := p.Provide(&sp)
+	assert(t, err != nil, "there's no rule for KrabbyPatty")
+	assert(t, p.LastError() == err, p.LastError())
+
+	var pt *Patrick
+	err = p.AddRule(func() *Patrick { return &Patrick{} })
+	assert(t, err == nil, err)
+	err = p.Provide(&pt)
+	assert(t, err == nil, err)
+	assert(t, p.LastError() == nil, p.LastError())
+}
+
+func TestProvideValueTypes(t *testing.T) {
+	p, err := provide.NewProvider(
+		func() KrabbyPatty { return "jabberwocky" },
+		func(kp KrabbyPatty) Patrick { return Patrick{Patty: kp + "!"} },
+	)
+	assert(t, err == nil, err)
+
+	// There is a rule for Patrick itself, so *Patrick is unrelated.
+	var pt Patrick
+	var star *Patrick
+	err = p.Provide(&pt, &star)
+	assert(t, err == nil, err)
+	assert(t, pt.Patty == "jabberwocky!", pt)
+	assert(t, star.Patty == "jabberwocky", star)
+
+	// There's no rule for Spongebob, so it's copied from *Spongebob.
+	var sp Spongebob
+	var spPtr *Spongebob
+	err = p.Provide(&sp, &spPtr)
+	assert(t, err == nil, err)
+	assert(t, sp == *spPtr, sp, spPtr)
+}
+
+func TestAddEager(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	var eager *Patrick
+	err = p.AddEager(func(kp KrabbyPatty) *Patrick {
+		eager = &Patrick{Patty: kp}
+		return eager
+	})
+	assert(t, err == nil, err)
+	assert(t, eager == nil, "eager rules wait for Provide")
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	assert(t, eager != nil && eager.Patty == "jabberwocky", eager)
+
+	var star *Patrick
+	err = p.Provide(&star)
+	assert(t, err == nil && star == eager, star, err)
+}
+
+type Jellyfish int
+
+type Jellyfishing struct {
+	Sponge *Spongebob `provide:""`
+	Star   *Patrick   `provide:""`
+	Catch  Jellyfish  `provide:""`
+}
+
+func TestParallelism(t *testing.T) {
+	for _, n := range []int{0, 1, 2} {
+		running, most := 0, 0
+		var mu sync.Mutex
+		slow := func() {
+			mu.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}
+
+		p, err := provide.NewProvider(
+			func() KrabbyPatty { slow(); return "jabberwocky" },
+			func() Jellyfish { slow(); return 3 },
+			func(kp KrabbyPatty) *Patrick { slow(); return &Patrick{Patty: kp} },
+			func() UnderSea { slow(); return Patrick{} },
+		)
+		assert(t, err == nil, err)
+		p.SetParallelism(n)
+
+		stop := p.StartTrace()
+		var j *Jellyfishing
+		var us UnderSea
+		err = p.Provide(&j, &us)
+		assert(t, err == nil, err)
+		assert(t, j.Sponge.Patty == "jabberwocky" && j.Star.Patty == "jabberwocky" && j.Catch == 3, j)
+		entries := stop()
+		assert(t, len(entries) == 8, n, entries)
+
+		if n == 1 {
+			assert(t, most == 1, n, most)
+		} else {
+			assert(t, most == 2, n, most)
+		}
+	}
+}
+
+type Sandy struct {
+	Patty   KrabbyPatty `provide:""`
+	Friend  *Spongebob  `provide:"optional"`
+	Home    UnderSea    `provide:"optional"`
+	Karate  InPineapple `provide:""`
+	Tree    string
+	Patrick *Patrick `provide:""`
+}
+
+func TestHydrate(t *testing.T) {
+	p, err := provide.NewProvider(
+		func() KrabbyPatty { return "jabberwocky" },
+		func() *Patrick { return &Patrick{Patty: "rock"} },
+	)
+	assert(t, err == nil, err)
+
+	already := &Patrick{}
+	s := &Sandy{Tree: "dome", Patrick: already}
+	err = p.Hydrate(s)
+	assert(t, err == nil, err)
+	assert(t, s.Patty == "jabberwocky", s)
+	assert(t, s.Friend != nil && s.Friend.Patty == "jabberwocky", s)
+	assert(t, s.Home == nil && s.Karate == nil, s)
+	assert(t, s.Tree == "dome" && s.Patrick == already, s)
+
+	err = p.Hydrate(Sandy{})
+	assert(t, err != nil, "Hydrate needs a pointer")
+}
+
+func TestRuleReturnsNilInterface(t *testing.T) {
+	p, err := provide.NewProvider(func() UnderSea { return nil })
+	assert(t, err == nil, err)
+
+	var us UnderSea
+	err = p.Provide(&us)
+	assert(t, err != nil && err.Error() == "rule for provide_test.UnderSea returned nil — a nil interface value cannot be used as a dependency", err)
+}
+
+func TestWithTransactional(t *testing.T) {
+	p, err := provide.NewProvider(provide.WithTransactional(), func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	var kp KrabbyPatty
+	var us UnderSea
+	err = p.Provide(&kp, &us)
+	assert(t, err != nil, "UnderSea can't be provided")
+	assert(t, kp == "", kp)
+
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "jabberwocky", kp, err)
+}
+
+func TestPointerToPointer(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	var spp **Spongebob
+	err = p.Provide(&spp)
+	assert(t, err != nil && strings.Contains(err.Error(), "pointer-to-pointer type **provide_test.Spongebob is not supported"), err)
+
+	p, err = provide.NewProvider(
+		func() KrabbyPatty { return "jabberwocky" },
+		func(sp *Spongebob) **Spongebob { return &sp },
+	)
+	assert(t, err == nil, err)
+	err = p.Provide(&spp)
+	assert(t, err == nil, err)
+	assert(t, (*spp).Patty == "jabberwocky", spp)
+}
+
+func TestAddMapRule(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	err = p.AddMapRule(func(pt Patrick) string { return string(pt.Patty) },
+		func(kp KrabbyPatty) Patrick { return Patrick{Patty: kp} },
+		func() (Patrick, error) { return Patrick{Patty: "pizza"}, nil },
+	)
+	assert(t, err == nil, err)
+
+	var m map[string]Patrick
+	err = p.Provide(&m)
+	assert(t, err == nil, err)
+	assert(t, len(m) == 2 && m["jabberwocky"].Patty == "jabberwocky" && m["pizza"].Patty == "pizza", m)
+
+	err = p.AddMapRule(func(kp KrabbyPatty) []string { return nil })
+	assert(t, err != nil, "slices can't be map keys")
+
+	p = &provide.Provider{}
+	err = p.AddMapRule(func(pt Patrick) string { return "same" },
+		func() Patrick { return Patrick{} },
+		func() Patrick { return Patrick{} },
+	)
+	assert(t, err == nil, err)
+	err = p.Provide(&m)
+	assert(t, err != nil, "both values have the same key")
+}
+
+func TestOnError(t *testing.T) {
+	p, err := provide.NewProvider(func() (KrabbyPatty, error) { return "", errors.New("out of patties") })
+	assert(t, err == nil, err)
+
+	var failed []reflect.Type
+	p.OnError(func(typ reflect.Type, err error) {
+		assert(t, err.Error() == "out of patties", err)
+		failed = append(failed, typ)
+	})
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err != nil, "there are no patties")
+	assert(t, len(failed) == 1 && failed[0] == reflect.TypeOf(sp), failed)
+}
+
+func TestAddPrerequisite(t *testing.T) {
+	var order []string
+	p, err := provide.NewProvider(
+		func() KrabbyPatty { order = append(order, "patty"); return "jabberwocky" },
+		func() *Patrick { order = append(order, "patrick"); return &Patrick{} },
+	)
+	assert(t, err == nil, err)
+
+	err = p.AddPrerequisite((*KrabbyPatty)(nil), (**Patrick)(nil))
+	assert(t, err == nil, err)
+	err = p.AddPrerequisite((**Spongebob)(nil), (*Squidward)(nil))
+	assert(t, err == nil, err)
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	assert(t, len(order) == 2 && order[0] == "patrick" && order[1] == "patty", order)
+
+	var squid Squidward
+	err = p.Provide(&squid)
+	assert(t, err == nil, err)
+
+	err = p.AddPrerequisite((*KrabbyPatty)(nil), (*UnderSea)(nil))
+	assert(t, err != nil, "KrabbyPatty has already been constructed")
+
+	p, err = provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+	err = p.AddPrerequisite((*KrabbyPatty)(nil), (**Spongebob)(nil))
+	assert(t, err == nil, err)
+	err = p.Provide(&sp)
+	assert(t, err != nil && strings.Contains(err.Error(), "cycle"), err)
+}
+
+func TestSnapshot(t *testing.T) {
+	var order []string
+	p, err := provide.NewProvider(
+		func() KrabbyPatty { order = append(order, "patty"); return "jabberwocky" },
+		func() *Patrick { order = append(order, "patrick"); return &Patrick{} },
+	)
+	assert(t, err == nil, err)
+
+	var star *Patrick
+	var kp KrabbyPatty
+	err = p.Provide(&star, &kp)
+	assert(t, err == nil, err)
+	snap := p.Snapshot()
+	init := snap.InitOrder()
+	assert(t, len(init) == 2 && init[0] == reflect.TypeOf(star) && init[1] == reflect.TypeOf(kp), init)
+
+	err = p.AddRule(func() UnderSea { return Patrick{} })
+	assert(t, err == nil, err)
+	var us UnderSea
+	err = p.Provide(&us)
+	assert(t, err == nil, err)
+
+	order = nil
+	err = p.RestoreSnapshot(snap)
+	assert(t, err == nil, err)
+	assert(t, len(order) == 2 && order[0] == "patrick" && order[1] == "patty", order)
+
+	var again *Patrick
+	err = p.Provide(&again)
+	assert(t, err == nil && again != star, again, err)
+	err = p.Provide(&us)
+	assert(t, err != nil, "the UnderSea rule was added after the snapshot")
+
+	// Prototypes and annotations are restored too.
+	p = &provide.Provider{}
+	err = p.AddPrototype(func() func() *Ticket { return func() *Ticket { return &Ticket{} } })
+	assert(t, err == nil, err)
+	err = p.AnnotateType((**Ticket)(nil), map[string]string{"owner": "krabs"})
+	assert(t, err == nil, err)
+	snap = p.Snapshot()
+	err = p.AnnotateType((**Ticket)(nil), map[string]string{"owner": "plankton"})
+	assert(t, err == nil, err)
+
+	err = p.RestoreSnapshot(snap)
+	assert(t, err == nil, err)
+	var a, b *Ticket
+	err = p.Provide(&a, &b)
+	assert(t, err == nil && a != b, a, b, err)
+	m := p.Manifest()
+	assert(t, len(m.Rules) == 2 && m.Rules[0].Annotations["owner"] == "krabs", m.Rules)
+}
+
+func TestMultiOutputRuleCalledOnce(t *testing.T) {
+	for _, parallelism := range []int{1, 0} {
+		calls := 0
+		p, err := provide.NewProvider(func() (KrabbyPatty, UnderSea) {
+			calls++
+			return "jabberwocky", Patrick{}
+		})
+		assert(t, err == nil, err)
+		p.SetParallelism(parallelism)
+
+		var us UnderSea
+		var kp KrabbyPatty
+		err = p.Provide(&us, &kp)
+		assert(t, err == nil && calls == 1, parallelism, calls, err)
+
+		init := p.Snapshot().InitOrder()
+		assert(t, len(init) == 2 && init[0] == reflect.TypeOf(kp), parallelism, init)
+
+		err = p.Replay()
+		assert(t, err == nil && calls == 2, parallelism, calls, err)
+	}
+}
+
+func TestSetOnMissingRule(t *testing.T) {
+	p := &provide.Provider{}
+	var missing []reflect.Type
+	p.SetOnMissingRule(func(typ reflect.Type) error {
+		missing = append(missing, typ)
+		if typ == reflect.TypeOf(KrabbyPatty("")) {
+			return p.AddRule(func() KrabbyPatty { return "generated" })
+		}
+		return nil
+	})
+
+	var sp *Spongebob
+	err := p.Provide(&sp)
+	assert(t, err == nil, err)
+	assert(t, sp.Patty == "generated", sp)
+
+	var us UnderSea
+	err = p.Provide(&us)
+	assert(t, err != nil, "nothing generates UnderSea")
+	assert(t, len(missing) == 2 && missing[1] == reflect.TypeOf((*UnderSea)(nil)).Elem(), missing)
+}
+
+type TwoPatties struct {
+	First  KrabbyPatty `provide:""`
+	Second KrabbyPatty `provide:""`
+}
+
+func TestDuplicateFieldTypes(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	var two *TwoPatties
+	err = p.Provide(&two)
+	assert(t, err != nil && err.Error() == `*provide_test.TwoPatties has two fields of the same type provide_test.KrabbyPatty both tagged provide:"" — use distinct types (type-aliases) to distinguish them`, err)
+}
+
+func TestAddLazyRule(t *testing.T) {
+	var calls int32
+	p := &provide.Provider{}
+	err := p.AddLazyRule(func() KrabbyPatty {
+		atomic.AddInt32(&calls, 1)
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	clones := []*provide.Provider{p, p.Clone(), p.Clone(), p.Clone()}
+	kps := make([]KrabbyPatty, len(clones))
+	errs := make([]error, len(clones))
+	var wg sync.WaitGroup
+	for i, c := range clones {
+		wg.Add(1)
+		go func(i int, c *provide.Provider) {
+			defer wg.Done()
+			errs[i] = c.Provide(&kps[i])
+		}(i, c)
+	}
+	wg.Wait()
+	for i := range clones {
+		assert(t, errs[i] == nil && kps[i] == "jabberwocky", kps[i], errs[i])
+	}
+	assert(t, calls == 1, calls)
+
+	// Another Provider the rule is applied to calls it for itself.
+	other := &provide.Provider{}
+	err = p.ApplyTo(other)
+	assert(t, err == nil, err)
+	var kp KrabbyPatty
+	err = other.Provide(&kp)
+	assert(t, err == nil && kp == "jabberwocky" && calls == 2, kp, calls, err)
+
+	err = p.AddLazyRule(42)
+	assert(t, err != nil, "42 isn't a rule")
+}
+
+func TestAddWithPriority(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddWithPriority(100, func() KrabbyPatty { return "chum" })
+	assert(t, err == nil, err)
+	err = p.AddRule(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, "the default is outranked")
+	err = p.AddWithPriority(100, func() KrabbyPatty { return "pizza" })
+	assert(t, err != nil, "same priority")
+	err = p.AddWithPriority(50, func() (KrabbyPatty, Squidward) { return "", Squidward{} })
+	assert(t, err == nil, "outranked, so nothing is added")
+
+	var kp KrabbyPatty
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "chum", kp, err)
+	err = p.AddWithPriority(200, func() KrabbyPatty { return "pickles" })
+	assert(t, err != nil, "KrabbyPatty was already constructed")
+
+	p = &provide.Provider{}
+	err = p.AddRule(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+	err = p.AddWithPriority(100, func() KrabbyPatty { return "chum" })
+	assert(t, err == nil, err)
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "chum", kp, err)
+}
+
+type Grill struct{ Patty KrabbyPatty }
+
+func (g *Grill) Cook() KrabbyPatty { return g.Patty }
+
+func TestAddRuleNilAndMethodValue(t *testing.T) {
+	p := &provide.Provider{}
+	var fn interface{}
+	err := p.AddRule(fn)
+	assert(t, err != nil, "nil rule")
+	err = p.AddRule((func() KrabbyPatty)(nil))
+	assert(t, err != nil, "nil function")
+	err = p.AddLazyRule((func() KrabbyPatty)(nil))
+	assert(t, err != nil, "nil function")
+
+	g := &Grill{Patty: "pickles"}
+	err = p.AddRule(g.Cook)
+	assert(t, err == nil, err)
+
+	var b strings.Builder
+	err = p.Summarize(&b, (*KrabbyPatty)(nil))
+	assert(t, err == nil, err)
+	assert(t, strings.HasSuffix(b.String(), "provide_test.(*Grill).Cook (method value)\n"), b.String())
+
+	var kp KrabbyPatty
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "pickles", kp, err)
+}
+
+func TestInspectRule(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddRule(func(sp *Spongebob) KrabbyPatty { return sp.Patty })
+	assert(t, err == nil, err)
+	err = p.AddValue(Squidward{})
+	assert(t, err == nil, err)
+
+	ins, isAuto, source, err := p.InspectRule((*KrabbyPatty)(nil))
+	assert(t, err == nil, err)
+	assert(t, !isAuto && len(ins) == 1 && ins[0] == reflect.TypeOf(&Spongebob{}), ins, isAuto)
+	assert(t, strings.Contains(source, "basic_test.go:"), source)
+
+	ins, isAuto, source, err = p.InspectRule((**Krusty)(nil))
+	assert(t, err == nil, err)
+	assert(t, isAuto && source == "", isAuto, source)
+	assert(t, len(ins) == 4 && ins[1] == reflect.TypeOf(KrabbyPatty("")) && ins[2] == reflect.TypeOf(Squidward{}), ins)
+
+	_, _, _, err = p.InspectRule((*Squidward)(nil))
+	assert(t, err != nil, "Squidward is a value")
+	_, _, _, err = p.InspectRule((*UnderSea)(nil))
+	assert(t, err != nil, "interfaces can't be automatically provided")
+}
+
+func TestAutoProvideValueStruct(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	var sp Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil && sp.Patty == "jabberwocky", sp, err)
+	states := p.TypesWithState(provide.TypeStateDone)
+	for _, typ := range states {
+		assert(t, typ != reflect.TypeOf(&Spongebob{}), "Spongebob is constructed without a *Spongebob", states)
+	}
+
+	p, err = provide.NewProvider(
+		func() KrabbyPatty { return "jabberwocky" },
+		func() *Spongebob { return &Spongebob{Patty: "pickles"} },
+	)
+	assert(t, err == nil, err)
+	err = p.Provide(&sp)
+	assert(t, err == nil && sp.Patty == "pickles", "the rule for *Spongebob is still used", sp, err)
+
+	p, err = provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+	var ptr *Spongebob
+	err = p.Provide(&ptr)
+	assert(t, err == nil && ptr.Patty == "jabberwocky", ptr, err)
+	ptr.Patty = "pickles"
+	sp = Spongebob{}
+	err = p.Provide(&sp)
+	assert(t, err == nil && sp.Patty == "jabberwocky", "Spongebob isn't copied from an automatically provided *Spongebob", sp, err)
+
+	p, err = provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+	err = p.AddValue(&Spongebob{Patty: "pickles"})
+	assert(t, err == nil, err)
+	err = p.Provide(&sp)
+	assert(t, err == nil && sp.Patty == "pickles", "an added *Spongebob is still used", sp, err)
+}
+
+func TestProvideAndRun(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	ran := false
+	err = p.ProvideAndRun(func(sp *Spongebob, kp KrabbyPatty) error {
+		ran = sp.Patty == kp
+		return nil
+	})
+	assert(t, err == nil && ran, err)
+
+	failed := errors.New("closed for the day")
+	err = p.ProvideAndRun(func(sp *Spongebob) error { return failed })
+	assert(t, err == failed, err)
+
+	ran = false
+	err = p.ProvideAndRun(func(us UnderSea) { ran = true })
+	assert(t, err != nil && !ran, err)
+	assert(t, p.LastError() == err, p.LastError())
+
+	err = p.ProvideAndRun(nil)
+	assert(t, err != nil, "nil function")
+}
+
+func TestAudit(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	sp := reflect.TypeOf(&Spongebob{})
+	kp := reflect.TypeOf(KrabbyPatty(""))
+	err = p.Audit(provide.ProviderPolicy{
+		RequiredTypes:     []reflect.Type{sp},
+		MaxTransitiveDeps: 1,
+	})
+	assert(t, err == nil, err)
+
+	err = p.Audit(provide.ProviderPolicy{
+		ForbiddenDependencies: []provide.DependencyEdge{{From: sp, To: kp}},
+		RequiredTypes:         []reflect.Type{sp, reflect.TypeOf((*UnderSea)(nil)).Elem()},
+		MaxTransitiveDeps:     1,
+	})
+	assert(t, err != nil, "two violations")
+	msg := err.Error()
+	assert(t, strings.Contains(msg, "missing required type provide_test.UnderSea"), msg)
+	assert(t, strings.Contains(msg, "*provide_test.Spongebob depends on provide_test.KrabbyPatty, which is forbidden"), msg)
+
+	err = p.Audit(provide.ProviderPolicy{RequiredTypes: []reflect.Type{reflect.TypeOf(&Krusty{})}, MaxTransitiveDeps: 2})
+	assert(t, err != nil && strings.Contains(err.Error(), "*provide_test.Krusty has "), err)
+}
+
+func TestProvideMulti(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	values, err := p.ProvideMulti((**Spongebob)(nil), (*KrabbyPatty)(nil))
+	assert(t, err == nil, err)
+	assert(t, len(values) == 2 && values[0].(*Spongebob).Patty == "jabberwocky" && values[1].(KrabbyPatty) == "jabberwocky", values)
+
+	_, err = p.ProvideMulti((*KrabbyPatty)(nil), (*UnderSea)(nil))
+	assert(t, err != nil && strings.Contains(err.Error(), "UnderSea"), err)
+	_, err = p.ProvideMulti(KrabbyPatty(""))
+	assert(t, err != nil, "not a type example")
+}
+
+func TestTypeGraph(t *testing.T) {
+	p, err := provide.NewProvider(
+		func() KrabbyPatty { return "jabberwocky" },
+		func(kp KrabbyPatty) UnderSea { return Patrick{Patty: kp} },
+	)
+	assert(t, err == nil, err)
+
+	graph := p.TypeGraph()
+	kp := reflect.TypeOf(KrabbyPatty(""))
+	us := reflect.TypeOf((*UnderSea)(nil)).Elem()
+	assert(t, len(graph) == 2 && len(graph[kp]) == 0 && len(graph[us]) == 1 && graph[us][0] == kp, graph)
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	graph = p.TypeGraph()
+	deps := graph[reflect.TypeOf(sp)]
+	assert(t, len(graph) == 3 && len(deps) == 1 && deps[0] == kp, graph)
+}
+
+type KrustyKrabModule struct{ Special KrabbyPatty }
+
+func (m *KrustyKrabModule) ProvideKrabbyPatty() KrabbyPatty { return m.Special }
+func (m *KrustyKrabModule) ProvideUnderSea(kp KrabbyPatty) UnderSea {
+	return Patrick{Patty: kp}
+}
+func (m *KrustyKrabModule) Close() {}
+
+func TestAddRuleFromStruct(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddRuleFromStruct(&KrustyKrabModule{Special: "pizza"})
+	assert(t, err == nil, err)
+
+	var us UnderSea
+	err = p.Provide(&us)
+	assert(t, err == nil && us.(Patrick).Patty == "pizza", us, err)
+	assert(t, len(p.Manifest().Rules) == 2, p.Manifest())
+
+	err = p.AddRuleFromStruct(KrustyKrabModule{})
+	assert(t, err != nil, "the methods have pointer receivers")
+	err = p.AddRuleFromStruct(&KrustyKrabModule{})
+	assert(t, err != nil, "the rules conflict")
+}
+
+func TestSetValueTransformer(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+	err = p.AddValue(Squidward{})
+	assert(t, err == nil, err)
+
+	var transformed []reflect.Type
+	p.SetValueTransformer(func(t reflect.Type, v reflect.Value) reflect.Value {
+		transformed = append(transformed, t)
+		if kp, ok := v.Interface().(KrabbyPatty); ok {
+			return reflect.ValueOf(kp + " with cheese")
+		}
+		return v
+	})
+
+	var sp *Spongebob
+	var squid Squidward
+	err = p.Provide(&sp, &squid)
+	assert(t, err == nil && sp.Patty == "jabberwocky with cheese", sp, err)
+	assert(t, len(transformed) == 2, "Squidward was added as a value", transformed)
+
+	p.SetValueTransformer(func(t reflect.Type, v reflect.Value) reflect.Value {
+		return reflect.ValueOf(42)
+	})
+	var pt *Patrick
+	err = p.Provide(&pt)
+	assert(t, err != nil, "an int can't be used as a *Patrick")
+}
+
+func TestWithSlogIntegration(t *testing.T) {
+	original := slog.Default()
+	defer slog.SetDefault(original)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	p, err := provide.NewProvider(provide.WithSlogIntegration(), func() *slog.Logger { return logger })
+	assert(t, err == nil, err)
+	err = p.ProvideInto(func(*slog.Logger) {})
+	assert(t, err == nil && slog.Default() == logger, err)
+
+	slog.SetDefault(original)
+	added := slog.New(slog.NewTextHandler(io.Discard, nil))
+	p, err = provide.NewProvider(provide.WithSlogIntegration())
+	assert(t, err == nil, err)
+	err = p.AddValue(added)
+	assert(t, err == nil, err)
+	assert(t, slog.Default() == original, "not until it's provided")
+	var l *slog.Logger
+	err = p.Provide(&l)
+	assert(t, err == nil && slog.Default() == added, err)
+
+	slog.SetDefault(original)
+	p = &provide.Provider{}
+	err = p.AddValue(added)
+	assert(t, err == nil, err)
+	err = p.Provide(&l)
+	assert(t, err == nil && slog.Default() == original, "only with WithSlogIntegration", err)
+}
+
+type Ticket struct {
+	Number int
+}
+
+type Register struct {
+	Ticket *Ticket `provide:""`
+}
+
+func TestPrototypeRule(t *testing.T) {
+	count := 0
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+	err = p.AddPrototype(func() func() *Ticket {
+		return func() *Ticket {
+			count++
+			return &Ticket{Number: count}
+		}
+	})
+	assert(t, err == nil, err)
+
+	var a, b *Ticket
+	err = p.Provide(&a)
+	assert(t, err == nil, err)
+	err = p.Provide(&b)
+	assert(t, err == nil && a != b, a, b, err)
+
+	var r *Register
+	err = p.Provide(&r)
+	assert(t, err == nil && r.Ticket != nil && r.Ticket != a && r.Ticket != b, r, err)
+
+	err = p.AddRule(func() *Ticket { return &Ticket{} })
+	assert(t, err != nil, "*Ticket is already provided by the prototype")
+	err = (&provide.Provider{}).AddPrototype(func() (*Ticket, func() *Ticket) { return nil, nil })
+	assert(t, err != nil, "both outputs provide *Ticket")
+	err = (&provide.Provider{}).AddPrototype(func() *Ticket { return nil })
+	assert(t, err != nil, "prototype rules must output a func() T")
+
+	// Only prototype rules provide T from a func() T.
+	p, err = provide.NewProvider(
+		func() *Ticket { return &Ticket{Number: 1} },
+		func() func() *Ticket { return func() *Ticket { return &Ticket{} } },
+	)
+	assert(t, err == nil, err)
+	err = p.Provide(&a, &b)
+	assert(t, err == nil && a.Number == 1 && a == b, a, b, err)
+}
+
+func TestCheckGraph(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	sp := reflect.TypeOf(&Spongebob{})
+	kp := reflect.TypeOf(KrabbyPatty(""))
+	err = p.CheckGraph(provide.GraphPolicy{
+		MaxDepth:      1,
+		RequiredEdges: [][2]reflect.Type{{sp, kp}},
+	}, (**Spongebob)(nil))
+	assert(t, err == nil, err)
+
+	err = p.CheckGraph(provide.GraphPolicy{
+		MaxDepth:       1,
+		ForbiddenEdges: [][2]reflect.Type{{sp, kp}},
+		RequiredEdges:  [][2]reflect.Type{{kp, sp}},
+	}, (**Spongebob)(nil), (**Krusty)(nil), (**Cycle1)(nil))
+	assert(t, err != nil, "every rule is broken")
+	msg := err.Error()
+	assert(t, strings.Contains(msg, "cycle: *provide_test.Cycle1 --> *provide_test.Cycle2 --> *provide_test.Cycle1"), msg)
+	assert(t, strings.Contains(msg, "*provide_test.Krusty has "), msg)
+	assert(t, strings.Contains(msg, "*provide_test.Spongebob depends on provide_test.KrabbyPatty, which is forbidden"), msg)
+	assert(t, strings.Contains(msg, "provide_test.KrabbyPatty is required to depend on *provide_test.Spongebob"), msg)
+
+	err = p.CheckGraph(provide.GraphPolicy{AllowCycles: true}, (**Cycle1)(nil))
+	assert(t, err == nil, err)
+
+	err = p.CheckGraph(provide.GraphPolicy{
+		ForbiddenEdges: [][2]reflect.Type{{sp, kp}},
+		RequiredEdges:  [][2]reflect.Type{{sp, reflect.TypeOf(&Krusty{})}},
+	}, (*KrabbyPatty)(nil))
+	assert(t, err == nil, "*Spongebob isn't in the graph of KrabbyPatty", err)
+}
+
+func TestProvideAs(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	var ip InPineapple
+	err = p.ProvideAs(&ip, (**Spongebob)(nil))
+	assert(t, err == nil && ip.(*Spongebob).Patty == "jabberwocky", ip, err)
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil && sp == ip, "it's the same *Spongebob", err)
+
+	var us UnderSea
+	err = p.ProvideAs(&us, (*KrabbyPatty)(nil))
+	assert(t, err != nil, "KrabbyPatty isn't UnderSea")
+	err = p.ProvideAs(us, (**Spongebob)(nil))
+	assert(t, err != nil, "not a pointer")
+}
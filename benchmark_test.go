@@ -0,0 +1,55 @@
+package provide_test
+
+import (
+	"github.com/MatthewValentine/provide"
+	"testing"
+)
+
+type Snail struct {
+	Patty KrabbyPatty
+}
+
+type AutoSnail struct {
+	Patty KrabbyPatty `provide:""`
+	Name  string
+}
+
+func (s *AutoSnail) PleaseProvide(kp KrabbyPatty) {
+	s.Name = "gary " + string(kp)
+}
+
+// BenchmarkCustomRule measures constructing a value with a rule, func(a A) *B.
+func BenchmarkCustomRule(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		p, err := provide.NewProvider(
+			func() KrabbyPatty { return "jabberwocky" },
+			func(kp KrabbyPatty) *Snail { return &Snail{Patty: kp} },
+		)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		var s *Snail
+		if err := p.Provide(&s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkAutoProvide measures automatically constructing a similar value,
+// with a provide-tagged field and PleaseProvide.
+func BenchmarkAutoProvide(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		p, err := provide.NewProvider(
+			func() KrabbyPatty { return "jabberwocky" },
+		)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		var s *AutoSnail
+		if err := p.Provide(&s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package provide
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+)
+
+// A ConstrainedProvider is a Provider that only provides values
+// once it's sure a set of required types can be provided (see WhenAll).
+type ConstrainedProvider struct {
+	p        *Provider
+	required []reflect.Type
+	err      error
+}
+
+// WhenAll returns a ConstrainedProvider that only provides values if every type
+// requiredTypes point to can be provided. requiredTypes are only used for their types,
+// so they're usually nil pointers:
+//
+//     err := p.WhenAll((**DB)(nil), (*Logger)(nil)).Provide(&plugin)
+//
+// This makes it possible to check a plugin's declared dependencies before trying to set it up.
+//
+func (p *Provider) WhenAll(requiredTypes ...interface{}) *ConstrainedProvider {
+	c := &ConstrainedProvider{p: p}
+	for _, example := range requiredTypes {
+		typ, err := exampleType(example)
+		if err != nil {
+			c.err = err
+			break
+		}
+		c.required = append(c.required, typ)
+	}
+	return c
+}
+
+// Provide works like Provider.Provide, except that it first checks that
+// all the required types can be provided, without constructing anything.
+// If any of them can't be, it returns all of the reasons together (see errors.Join)
+// and doesn't provide anything.
+func (c *ConstrainedProvider) Provide(ptrsToRequests ...interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.p.init()
+	var errs []error
+	for _, typ := range c.required {
+		if err := c.p.check(typ); err != nil {
+			errs = append(errs, errors.New("missing required type "+typ.String()+": "+err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return c.p.Provide(ptrsToRequests...)
+}
+
+// check reports why a type can't be provided, if it can't,
+// by following its dependencies without constructing anything.
+func (p *Provider) check(typ reflect.Type) error {
+	if IsErrorType(typ) || typ.Implements(errorType) {
+		return errors.New(typ.String() + " implements error, so it cannot be provided")
+	}
+	return p.checkTask(task{typ, true}, nil, make(map[task]bool))
+}
+
+func (p *Provider) checkTask(t task, stack []task, checked map[task]bool) error {
+	if checked[t] {
+		return nil
+	}
+	for i, onStack := range stack {
+		if onStack == t {
+			cycle := make([]reflect.Type, 0, len(stack)-i+1)
+			for _, s := range stack[i:] {
+				cycle = append(cycle, s.Type)
+			}
+			return p.cycleError(append(cycle, t.Type))
+		}
+	}
+
+	s, err := p.peek(t)
+	if err != nil {
+		return err
+	}
+	if s.From != nil && !s.Done {
+		if err := s.From.check(t.Type); err != nil {
+			return err
+		}
+	}
+
+	if !s.Done {
+		stack = append(stack, t)
+		for _, dep := range s.DependsOn {
+			if err := p.checkTask(dep, stack, checked); err != nil {
+				return err
+			}
+		}
+	}
+	checked[t] = true
+	return nil
+}
+
+// A DependencyEdge is a direct dependency of one type on another.
+type DependencyEdge struct {
+	From reflect.Type
+	To   reflect.Type
+}
+
+// A ProviderPolicy describes the rules a Provider's dependencies are expected to follow (see Audit).
+type ProviderPolicy struct {
+	// ForbiddenDependencies are dependencies no type may have directly,
+	// such as the HTTP layer depending on the database.
+	ForbiddenDependencies []DependencyEdge
+
+	// RequiredTypes must all be possible to provide.
+	RequiredTypes []reflect.Type
+
+	// MaxTransitiveDeps limits how many types any type may depend on, directly or indirectly.
+	// Zero means there's no limit.
+	MaxTransitiveDeps int
+}
+
+// Audit checks that a Provider follows a policy, without constructing anything,
+// so that the architecture of an application can be enforced by a test:
+//
+//     err := p.Audit(provide.ProviderPolicy{
+//         ForbiddenDependencies: []provide.DependencyEdge{
+//             {From: reflect.TypeOf((*Handler)(nil)), To: reflect.TypeOf((*DB)(nil))},
+//         },
+//         MaxTransitiveDeps: 20,
+//     })
+//
+// The types checked against MaxTransitiveDeps are the outputs of the Provider's rules,
+// the required types, and everything they depend on.
+// Every violation is returned together (see errors.Join).
+//
+func (p *Provider) Audit(policy ProviderPolicy) error {
+	p.init()
+
+	var errs []error
+	for _, typ := range policy.RequiredTypes {
+		if err := p.check(typ); err != nil {
+			errs = append(errs, errors.New("missing required type "+typ.String()+": "+err.Error()))
+		}
+	}
+
+	var types []reflect.Type
+	for _, r := range p.rules {
+		types = append(types, r.outputs()...)
+	}
+	types = append(types, policy.RequiredTypes...)
+	return errors.Join(append(errs, p.auditDeps(policy, types)...)...)
+}
+
+// auditDeps checks types, and everything they depend on, against a policy's
+// ForbiddenDependencies and MaxTransitiveDeps (see Audit and CheckGraph).
+func (p *Provider) auditDeps(policy ProviderPolicy, types []reflect.Type) []error {
+	var errs []error
+	for _, edge := range policy.ForbiddenDependencies {
+		if deps, err := p.typeDeps(edge.From); err == nil && containsType(deps, edge.To) {
+			errs = append(errs, errors.New(edge.From.String()+" depends on "+edge.To.String()+", which is forbidden"))
+		}
+	}
+	if policy.MaxTransitiveDeps <= 0 {
+		return errs
+	}
+
+	seen := make(map[reflect.Type]bool)
+	for i := 0; i < len(types); i++ {
+		typ := types[i]
+		if seen[typ] {
+			continue
+		}
+		seen[typ] = true
+
+		all, err := p.allTypeDeps(typ)
+		if err != nil {
+			// Types that can't be provided at all aren't limited.
+			continue
+		}
+		if len(all) > policy.MaxTransitiveDeps {
+			errs = append(errs, errors.New(
+				typ.String()+" has "+strconv.Itoa(len(all))+" transitive dependencies, more than the maximum of "+strconv.Itoa(policy.MaxTransitiveDeps),
+			))
+		}
+		types = append(types, all...)
+	}
+	return errs
+}
+
+// A GraphPolicy describes the shape the dependency graph reachable from some types
+// is expected to have (see CheckGraph). Edges are pairs of types, the first depending directly on the second.
+type GraphPolicy struct {
+	// AllowCycles allows cycles that would make some types impossible to provide.
+	AllowCycles bool
+
+	// MaxDepth limits how many types any type in the graph may depend on, directly or indirectly,
+	// like ProviderPolicy.MaxTransitiveDeps. Zero means there's no limit.
+	MaxDepth int
+
+	// ForbiddenEdges are like ProviderPolicy.ForbiddenDependencies, but only for types in the graph.
+	ForbiddenEdges [][2]reflect.Type
+	RequiredEdges  [][2]reflect.Type
+}
+
+// CheckGraph checks the dependency graph of the types roots point to against a policy,
+// without constructing anything:
+//
+//     err := p.CheckGraph(provide.GraphPolicy{
+//         MaxDepth:      20,
+//         RequiredEdges: [][2]reflect.Type{{reflect.TypeOf((*Server)(nil)), reflect.TypeOf((*Metrics)(nil))}},
+//     }, (**Server)(nil))
+//
+// Edges are only checked for types in the graph, so a required or forbidden edge
+// from a type none of the roots depend on doesn't matter.
+// Every violation is returned together (see errors.Join), along with any of the roots that can't be provided.
+//
+func (p *Provider) CheckGraph(policy GraphPolicy, roots ...interface{}) error {
+	p.init()
+
+	var errs []error
+	if !policy.AllowCycles {
+		cycles, err := p.FindCycles(roots...)
+		if err != nil {
+			return err
+		}
+		for _, cycle := range cycles {
+			errs = append(errs, errors.New("cycle: "+typeNames(append(cycle, cycle[0]))))
+		}
+	}
+
+	var types []reflect.Type
+	reachable := make(map[reflect.Type]bool)
+	for _, root := range roots {
+		typ, err := exampleType(root)
+		if err != nil {
+			return err
+		}
+		all, err := p.allTypeDeps(typ)
+		if err != nil {
+			errs = append(errs, errors.New("can't check the dependencies of "+typ.String()+": "+err.Error()))
+			continue
+		}
+		types = append(types, typ)
+		reachable[typ] = true
+		for _, dep := range all {
+			reachable[dep] = true
+		}
+	}
+
+	// The rest of the policy is checked the same way as Audit checks a ProviderPolicy,
+	// but only for the edges of types in the graph.
+	audit := ProviderPolicy{MaxTransitiveDeps: policy.MaxDepth}
+	for _, edge := range policy.ForbiddenEdges {
+		if reachable[edge[0]] {
+			audit.ForbiddenDependencies = append(audit.ForbiddenDependencies, DependencyEdge{From: edge[0], To: edge[1]})
+		}
+	}
+	errs = append(errs, p.auditDeps(audit, types)...)
+
+	for _, edge := range policy.RequiredEdges {
+		if !reachable[edge[0]] {
+			continue
+		}
+		if deps, err := p.typeDeps(edge[0]); err != nil || !containsType(deps, edge[1]) {
+			errs = append(errs, errors.New(edge[0].String()+" is required to depend on "+edge[1].String()+", but doesn't"))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,29 @@
+package provide
+
+import "reflect"
+
+// inherit creates an initializer that constructs a value in parent
+// and then shares it.
+func inherit(parent *Provider, typ reflect.Type) initializer {
+	return initializer{
+		Type: typ,
+		Partial: state{
+			From:      parent,
+			Exclusive: true,
+			Do: func(values map[reflect.Type]reflect.Value) error {
+				unlock := parent.lock()
+				defer unlock()
+
+				if err := parent.complete(typ); err != nil {
+					return err
+				}
+
+				values[typ] = parent.values[typ]
+				return nil
+			},
+		},
+		Complete: state{
+			DependsOn: []task{{typ, false}},
+		},
+	}
+}
@@ -0,0 +1,249 @@
+package provide_test
+
+import (
+	"context"
+	"github.com/MatthewValentine/provide"
+	"testing"
+)
+
+func TestFactory(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	orders := 0
+	err = p.AddFactory(func(kp KrabbyPatty) *Order {
+		orders++
+		return &Order{Patty: kp, Number: orders}
+	})
+	assert(t, err == nil, err)
+
+	var parentOrder *Order
+	err = p.Provide(&parentOrder)
+	assert(t, err == nil, err)
+	assert(t, parentOrder.Number == 1, parentOrder)
+
+	for i := 2; i <= 3; i++ {
+		child := p.NewChild()
+
+		var order, sameOrder *Order
+		var sp *Spongebob
+		err = child.Provide(&order, &sameOrder, &sp)
+		assert(t, err == nil, err)
+		assert(t, order.Number == i, order)
+		assert(t, order == sameOrder, order, sameOrder)
+		assert(t, order.Patty == "jabberwocky", order)
+
+		var parentSp *Spongebob
+		err = p.Provide(&parentSp)
+		assert(t, err == nil, err)
+		assert(t, sp == parentSp, sp, parentSp)
+	}
+}
+
+type Order struct {
+	Patty  KrabbyPatty
+	Number int
+}
+
+func TestRequestScope(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	err = p.AddFactory(func(ctx context.Context, kp KrabbyPatty, n int) *Order {
+		return &Order{Patty: kp, Number: n + ctx.Value(orderKey{}).(int)}
+	})
+	assert(t, err == nil, err)
+
+	ctx := context.WithValue(context.Background(), orderKey{}, 10)
+	scope := p.NewRequestScope(ctx, 5)
+
+	var gotCtx context.Context
+	var order *Order
+	err = scope.Provide(&gotCtx, &order)
+	assert(t, err == nil, err)
+	assert(t, gotCtx == ctx, gotCtx)
+	assert(t, order.Number == 15, order)
+
+	var parentOrder *Order
+	err = p.Provide(&parentOrder)
+	assert(t, err != nil, "the parent has no int or context")
+}
+
+type orderKey struct{}
+
+func TestBindContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), orderKey{}, &Order{Number: 7})
+
+	p := &provide.Provider{}
+	err := p.BindContext((**Order)(nil), ctx, orderKey{})
+	assert(t, err == nil, err)
+
+	err = p.BindContext((*KrabbyPatty)(nil), ctx, orderKey{})
+	assert(t, err != nil, "mismatched types")
+
+	err = p.BindContext((*KrabbyPatty)(nil), ctx, "missing")
+	assert(t, err != nil, "missing value")
+
+	var order *Order
+	err = p.Provide(&order)
+	assert(t, err == nil, err)
+	assert(t, order.Number == 7, order)
+}
+
+func TestContains(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+	err = p.AddFactory(func(kp KrabbyPatty) *Order {
+		return &Order{Patty: kp}
+	})
+	assert(t, err == nil, err)
+	child := p.NewChild()
+
+	assert(t, !p.Contains((*KrabbyPatty)(nil)))
+	assert(t, !p.Contains(KrabbyPatty("")))
+
+	var order *Order
+	err = p.Provide(&order)
+	assert(t, err == nil, err)
+	assert(t, p.Contains((*KrabbyPatty)(nil)))
+	assert(t, p.Contains((**Order)(nil)))
+	assert(t, child.Contains((*KrabbyPatty)(nil)))
+	assert(t, !child.Contains((**Order)(nil)))
+}
+
+func TestScopedProvider(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	orders := 0
+	err = p.AddScopedRule("lunch", func(kp KrabbyPatty) *Order {
+		orders++
+		return &Order{Patty: kp, Number: orders}
+	})
+	assert(t, err == nil, err)
+	err = p.AddScopedRule("dinner", func() *Order {
+		return &Order{Patty: "dinner"}
+	})
+	assert(t, err == nil, err)
+	err = p.AddScopedRule("dinner", func() *Order {
+		return &Order{Patty: "second dinner"}
+	})
+	assert(t, err != nil, "conflicting rules within a scope")
+
+	var first, second, dinner *Order
+	err = p.NewScopedProvider("lunch").Provide(&first)
+	assert(t, err == nil, err)
+	err = p.NewScopedProvider("lunch").Provide(&second)
+	assert(t, err == nil, err)
+	err = p.NewScopedProvider("dinner").Provide(&dinner)
+	assert(t, err == nil, err)
+	assert(t, first.Number == 1 && second.Number == 2, first, second)
+	assert(t, dinner.Patty == "dinner", dinner)
+
+	var unscoped *Order
+	err = p.Provide(&unscoped)
+	assert(t, err != nil, "scoped rules shouldn't apply outside their scope")
+}
+
+func TestAddShared(t *testing.T) {
+	pool, err := provide.NewProvider(
+		func() KrabbyPatty { return "jabberwocky" },
+		func() UnderSea { return Patrick{} },
+	)
+	assert(t, err == nil, err)
+	var sp *Spongebob
+	err = pool.Provide(&sp)
+	assert(t, err == nil, err)
+
+	p := &provide.Provider{}
+	err = p.AddShared(pool)
+	assert(t, err == nil, err)
+	err = p.AddShared(p)
+	assert(t, err != nil, "a Provider can't share with itself")
+
+	var shared *Spongebob
+	err = p.Provide(&shared)
+	assert(t, err == nil, err)
+	assert(t, shared == sp, shared, sp)
+
+	var squid Squidward
+	err = p.Provide(&squid)
+	assert(t, err == nil, err)
+	assert(t, squid.Patty == "jabberwocky", squid)
+
+	// Only values are shared, not rules.
+	var us UnderSea
+	err = p.Provide(&us)
+	assert(t, err != nil, "UnderSea was never constructed in the pool")
+}
+
+type Tenant struct {
+	_     struct{}    `provide-scope:"bikini-bottom"`
+	Patty KrabbyPatty `provide:""`
+}
+
+func TestOfScope(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+	err = p.AddScopedRule("bikini-bottom", func() UnderSea { return Patrick{} })
+	assert(t, err == nil, err)
+
+	var tenant *Tenant
+	err = p.Provide(&tenant)
+	assert(t, err != nil, "Tenant is only in the bikini-bottom scope")
+
+	view := p.OfScope("bikini-bottom")
+	var us UnderSea
+	err = view.Provide(&tenant, &us)
+	assert(t, err == nil, err)
+	assert(t, tenant.Patty == "jabberwocky", tenant)
+
+	err = p.OfScope("rock-bottom").Provide(&us)
+	assert(t, err != nil, "UnderSea is only in the bikini-bottom scope")
+	err = p.OfScope("rock-bottom").Provide(&tenant)
+	assert(t, err != nil, "Tenant is only in the bikini-bottom scope")
+}
+
+func TestAddConditionalModule(t *testing.T) {
+	module, err := provide.NewProvider(
+		func() KrabbyPatty { return "pizza" },
+		func(kp KrabbyPatty) UnderSea { return Patrick{Patty: kp} },
+	)
+	assert(t, err == nil, err)
+
+	enabled := false
+	checked := 0
+	p := &provide.Provider{}
+	err = p.AddConditionalModule(func() bool {
+		checked++
+		return enabled
+	}, module)
+	assert(t, err == nil, err)
+	assert(t, checked == 0, "the condition isn't checked until it's needed")
+
+	c := p.Clone()
+	enabled = true
+	var us UnderSea
+	err = c.Provide(&us)
+	assert(t, err == nil && us.(Patrick).Patty == "pizza", us, err)
+
+	enabled = false
+	var kp KrabbyPatty
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "pizza", "the condition was already checked", kp, err)
+	assert(t, checked == 1, checked)
+
+	p = &provide.Provider{}
+	err = p.AddConditionalModule(func() bool { return false }, module)
+	assert(t, err == nil, err)
+	err = p.Provide(&us)
+	assert(t, err != nil, "the condition is false")
+}
@@ -0,0 +1,79 @@
+package provide
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// A Module is anything that can add rules to a Provider,
+// such as another Provider (see ApplyTo).
+type Module interface {
+	ApplyTo(p *Provider) error
+}
+
+// AddConditionalModule adds the rules m adds, which only apply if condition returns true.
+// The condition isn't checked until one of the rules is first needed,
+// so it can depend on something that's only known after the Provider is set up, like a feature flag:
+//
+//     err := p.AddConditionalModule(func() bool { return flags.Enabled("new-search") }, searchModule)
+//
+// The condition is checked at most once, and the answer applies to all of m's rules.
+// If it's false, the types m's rules output can't be provided.
+//
+func (p *Provider) AddConditionalModule(condition func() bool, m Module) error {
+	p.init()
+	if condition == nil || m == nil {
+		return errors.New("AddConditionalModule needs a condition and a module")
+	}
+
+	module := &Provider{}
+	if err := m.ApplyTo(module); err != nil {
+		return err
+	}
+
+	c := &moduleCondition{fn: condition}
+	var errs []error
+	for _, r := range append(module.rules, module.scopedRules...) {
+		r.Condition = c
+		if err := p.addRule(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// A moduleCondition is checked once, the first time it's needed.
+type moduleCondition struct {
+	fn   func() bool
+	once sync.Once
+	met  bool
+}
+
+func (c *moduleCondition) check() bool {
+	c.once.Do(func() {
+		c.met = c.fn()
+	})
+	return c.met
+}
+
+// conditional changes an initializer so that it fails unless a condition is met.
+// The condition is checked before any of the dependencies are constructed.
+func (p *Provider) conditional(init initializer, c *moduleCondition) initializer {
+	deps := init.Partial.DependsOn
+	do := init.Partial.Do
+	init.Partial.DependsOn = nil
+	init.Partial.Exclusive = true
+	init.Partial.Do = func(values map[reflect.Type]reflect.Value) error {
+		if !c.check() {
+			return errors.New(init.Type.String() + " isn't provided, since the condition for its module (see AddConditionalModule) is false")
+		}
+		for _, dep := range deps {
+			if err := p.complete(dep.Type); err != nil {
+				return err
+			}
+		}
+		return do(values)
+	}
+	return init
+}
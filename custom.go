@@ -2,16 +2,27 @@ package provide
 
 import (
 	"errors"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 func customProvide(provideFn interface{}) ([]initializer, error) {
 	v := reflect.ValueOf(provideFn)
+	if !v.IsValid() {
+		return nil, errors.New("providers must be functions, not nil")
+	}
 	t := v.Type()
 
 	if t.Kind() != reflect.Func {
 		return nil, errors.New("providers must be functions")
 	}
+	if v.IsNil() {
+		return nil, errors.New("providers must be functions, not a nil " + t.String())
+	}
 
 	ins := make([]reflect.Type, t.NumIn())
 	deps := make([]task, len(ins))
@@ -30,35 +41,53 @@ func customProvide(provideFn interface{}) ([]initializer, error) {
 		out := t.Out(i)
 		outs[i] = output{
 			Type:  out,
-			IsErr: isErrorType(out),
+			IsErr: IsErrorType(out),
 		}
-	}
 
-	alreadyDone := false
-	doFn := func(values map[reflect.Type]reflect.Value) error {
-		if alreadyDone {
-			return nil
+		for _, in := range ins {
+			if in == out && !outs[i].IsErr {
+				return nil, errors.New(
+					"rule for " + out.String() + " depends on " + in.String() + " (same type is both input and output — this always creates a cycle)",
+				)
+			}
 		}
-		alreadyDone = true
+	}
 
+	// Every output of the rule depends on this same function, but once it has been called
+	// for one of them, the tasks for the rest are marked done (see Provider.doneWith).
+	doFn := func(values map[reflect.Type]reflect.Value) error {
 		inputs := make([]reflect.Value, len(ins))
 		for i := range ins {
 			inputs[i] = values[ins[i]]
 		}
 
 		outputs := v.Call(inputs)
+		for i := range outputs {
+			if outs[i].IsErr && !outputs[i].IsNil() {
+				return outputs[i].Interface().(error)
+			}
+		}
 		for i := range outputs {
 			if outs[i].IsErr {
-				if !outputs[i].IsNil() {
-					return outputs[i].Interface().(error)
-				}
-			} else {
-				values[outs[i].Type] = outputs[i]
+				continue
 			}
+			if outs[i].Type.Kind() == reflect.Interface && outputs[i].IsNil() {
+				return errors.New(
+					"rule for " + outs[i].Type.String() + " returned nil — a nil interface value cannot be used as a dependency",
+				)
+			}
+			values[outs[i].Type] = outputs[i]
 		}
 		return nil
 	}
 
+	var outputs []reflect.Type
+	for i := range outs {
+		if !outs[i].IsErr {
+			outputs = append(outputs, outs[i].Type)
+		}
+	}
+
 	initializers := make([]initializer, 0, len(outs))
 	for i := range outs {
 		if outs[i].IsErr {
@@ -67,9 +96,200 @@ func customProvide(provideFn interface{}) ([]initializer, error) {
 
 		initializers = append(initializers, initializer{
 			Type:     outs[i].Type,
-			Partial:  state{DependsOn: deps, Do: doFn},
+			Partial:  state{DependsOn: deps, Do: doFn, Outputs: outputs},
 			Complete: state{DependsOn: []task{{outs[i].Type, false}}},
 		})
 	}
+
 	return initializers, nil
 }
+
+// prototypeOf finds the type provided by a prototype function type: a func() T, for which
+// a Provider calls the function to get a new T for everything that depends on T.
+// Named function types aren't prototypes, so they can be provided as plain values.
+func prototypeOf(fnType reflect.Type) (reflect.Type, bool) {
+	if fnType.Kind() != reflect.Func || fnType.Name() != "" || fnType.NumIn() != 0 || fnType.NumOut() != 1 || IsErrorType(fnType.Out(0)) {
+		return nil, false
+	}
+	return fnType.Out(0), true
+}
+
+// prototype creates an initializer that provides a type by calling the prototype function for it.
+// This only provides its first value; see Provider.refreshPrototypes for the rest.
+func prototype(fnType, typ reflect.Type) initializer {
+	return initializer{
+		Type: typ,
+		Partial: state{
+			DependsOn: []task{{fnType, true}},
+			Do: func(values map[reflect.Type]reflect.Value) error {
+				values[typ] = values[fnType].Call(nil)[0]
+				return nil
+			},
+		},
+		Complete: state{
+			DependsOn: []task{{typ, false}},
+		},
+	}
+}
+
+func ruleWarnings(t reflect.Type) []string {
+	var warnings []string
+	nIn := t.NumIn()
+	for i := 0; i < nIn; i++ {
+		for j := 0; j < i; j++ {
+			if t.In(i) == t.In(j) {
+				warnings = append(warnings,
+					"rule takes more than one "+t.In(i).String()+", which will always be the same value",
+				)
+			}
+		}
+	}
+
+	nOut := t.NumOut()
+	for i := 0; i < nOut; i++ {
+		out := t.Out(i)
+		if out.Kind() != reflect.Interface || IsErrorType(out) {
+			continue
+		}
+
+		selectsInput := false
+		for j := 0; j < nIn; j++ {
+			if t.In(j).Implements(out) {
+				selectsInput = true
+				break
+			}
+		}
+		if !selectsInput {
+			warnings = append(warnings,
+				"rule for interface "+out.String()+" doesn't select one of its inputs as the implementation;"+
+					" consider constructing the concrete type in one rule and binding it to "+out.String()+" in another",
+			)
+		}
+	}
+	return warnings
+}
+
+// validateRuleTypes eagerly validates (see ValidateType) the
+// automatically-provided types a rule takes or outputs.
+func validateRuleTypes(t reflect.Type, defaultTag string) error {
+	types := make([]reflect.Type, 0, t.NumIn()+t.NumOut())
+	for i := 0; i < t.NumIn(); i++ {
+		types = append(types, t.In(i))
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		types = append(types, t.Out(i))
+	}
+
+	for _, typ := range types {
+		elem := typ
+		if elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() == reflect.Struct {
+			if err := validateType(typ, defaultTag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prototypes adds initializers for the types provided by the prototype functions
+// a prototype rule outputs (see AddPrototype) to the rule's own initializers.
+func prototypes(initializers []initializer) ([]initializer, error) {
+	outputs := make([]reflect.Type, len(initializers))
+	for i, init := range initializers {
+		outputs[i] = init.Type
+	}
+
+	for _, out := range outputs {
+		elem, ok := prototypeOf(out)
+		if !ok {
+			continue
+		}
+		if containsType(outputs, elem) {
+			return nil, errors.New(
+				"rule outputs both " + elem.String() + " and " + out.String() + ", which already provides " + elem.String(),
+			)
+		}
+		initializers = append(initializers, prototype(out, elem))
+	}
+	if len(initializers) == len(outputs) {
+		return nil, errors.New("prototype rules must output a func() T")
+	}
+	return initializers, nil
+}
+
+// ruleOutputs returns the types a rule function outputs, other than errors.
+func ruleOutputs(provideFn interface{}) []reflect.Type {
+	t := reflect.TypeOf(provideFn)
+	var outs []reflect.Type
+	for i := 0; i < t.NumOut(); i++ {
+		if out := t.Out(i); !IsErrorType(out) {
+			outs = append(outs, out)
+		}
+	}
+	return outs
+}
+
+// outputs returns the types a rule provides: those its function outputs,
+// followed by the types provided by the prototype functions it outputs, for a prototype rule.
+func (r rule) outputs() []reflect.Type {
+	outs := ruleOutputs(r.Fn)
+	if !r.IsPrototype {
+		return outs
+	}
+	for _, out := range outs[:len(outs):len(outs)] {
+		if elem, ok := prototypeOf(out); ok {
+			outs = append(outs, elem)
+		}
+	}
+	return outs
+}
+
+// ruleSource describes where a rule function was defined, such as "main.NewServer (server.go:12)".
+// Method values (like server.Handler) are described by their method, such as "main.(*Server).Handler (method value)",
+// since the function that binds the receiver has no source of its own.
+func ruleSource(provideFn interface{}) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(provideFn).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+
+	name := fn.Name()
+	file, line := fn.FileLine(fn.Entry())
+	if strings.HasSuffix(name, "-fm") {
+		name = strings.TrimSuffix(name, "-fm")
+		if file == "<autogenerated>" {
+			return name + " (method value)"
+		}
+	}
+	return name + " (" + filepath.Base(file) + ":" + strconv.Itoa(line) + ")"
+}
+
+// A lazyCall calls a rule function at most once, remembering what it returned.
+type lazyCall struct {
+	once    sync.Once
+	outputs []reflect.Value
+}
+
+// wrap returns a function with the same signature as provideFn,
+// which calls provideFn the first time it's called, and returns the same results every time.
+// provideFn must already be known to be a function.
+func (l *lazyCall) wrap(provideFn interface{}) interface{} {
+	v := reflect.ValueOf(provideFn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return provideFn
+	}
+
+	return reflect.MakeFunc(v.Type(), func(args []reflect.Value) []reflect.Value {
+		l.once.Do(func() {
+			if v.Type().IsVariadic() {
+				l.outputs = v.CallSlice(args)
+			} else {
+				l.outputs = v.Call(args)
+			}
+		})
+		return l.outputs
+	}).Interface()
+}
@@ -0,0 +1,90 @@
+package provide
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A Diff describes how the rules and values of two Providers differ (see Provider.Diff).
+// It marshals to JSON as a list of changes sorted by type name, so it's stable enough
+// to commit and compare in CI:
+//
+//     [{"type":"*app.DB","change":"modified","details":"changed from a rule taking (app.Config) to a rule taking (*app.Logger, app.Config)"}]
+//
+type Diff []DiffEntry
+
+// A DiffEntry is a single change between two Providers.
+// Change is "added", "removed", or "modified". For modified types,
+// Details describes what changed about how the type is provided.
+type DiffEntry struct {
+	Type    string `json:"type"`
+	Change  string `json:"change"`
+	Details string `json:"details,omitempty"`
+}
+
+// Diff compares the rules and values that have been added to p and other (see Manifest),
+// describing what other adds, removes, or changes compared to p.
+// Types that are automatically provided are not compared.
+func (p *Provider) Diff(other *Provider) Diff {
+	before := manifestSources(p.Manifest())
+	after := manifestSources(other.Manifest())
+
+	var d Diff
+	for name, was := range before {
+		is, ok := after[name]
+		switch {
+		case !ok:
+			d = append(d, DiffEntry{Type: name, Change: "removed"})
+		case is != was:
+			d = append(d, DiffEntry{Type: name, Change: "modified", Details: "changed from " + was + " to " + is})
+		}
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			d = append(d, DiffEntry{Type: name, Change: "added"})
+		}
+	}
+
+	d.sort()
+	return d
+}
+
+// MarshalJSON implements json.Marshaler, sorting the changes by type name.
+func (d Diff) MarshalJSON() ([]byte, error) {
+	sorted := append(Diff(nil), d...)
+	sorted.sort()
+	if sorted == nil {
+		sorted = Diff{}
+	}
+	return json.Marshal([]DiffEntry(sorted))
+}
+
+func (d Diff) sort() {
+	sort.Slice(d, func(i, j int) bool {
+		return d[i].Type < d[j].Type
+	})
+}
+
+// manifestSources describes how each type in a Manifest is provided,
+// such as "a value" or "a rule taking (app.Config)", by type name.
+func manifestSources(m Manifest) map[string]string {
+	sources := make(map[string]string, len(m.Rules)+len(m.Values))
+	for _, r := range m.Rules {
+		sources[r.Type.String()] = "a rule taking (" + inputNames(r.Inputs) + ")"
+	}
+	for _, v := range m.Values {
+		sources[v.Type.String()] = "a value"
+	}
+	return sources
+}
+
+func inputNames(types []reflect.Type) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -60,6 +60,24 @@
 // If you want Providers to automatically construct your type but it doesn't
 // actually have any dependencies, simply add an empty PleaseProvide method.
 //
+// A field of a non-pointer type T annotated with `provide:"ptr"` is set by
+// providing a *T and dereferencing it, which saves writing a rule like
+// func(c *Config) Config { return *c } when only the pointer has a rule.
+//
+// Dependencies are always constructed in the order they are declared:
+// annotated fields from first to last, then the arguments of PleaseProvide.
+// Likewise, the inputs of a rule are constructed in the order of its arguments.
+//
+// Values of a non-pointer type T are normally provided by constructing a *T
+// and dereferencing it. But if PleaseProvide has a value receiver, T is constructed
+// directly instead, with PleaseProvide acting as a final check on the value.
+// A struct with annotated fields and no PleaseProvide at all is also constructed directly,
+// unless there's a rule for *T.
+//
+// Pointer-to-pointer types such as **T are never automatically provided,
+// even if *T can be. If you need one, add a rule for it, such as
+// func(t *T) **T { return &t }.
+//
 // If you give a Provider a rule that outputs an automatically-constructable type,
 // the rule will take precedence and the automatic construction will not occur.
 // In that case, it is up to the rule to make sure the value has been properly initialized.
@@ -85,6 +103,22 @@
 // further after being set, only pointers, maps, and channel fields can
 // be circular fields.
 //
+// Factories and child Providers
+//
+// Sometimes a value should be constructed once per scope, such as once per request,
+// rather than once per Provider. Rules added with AddFactory are used like
+// any other rule, but every child Provider created with NewChild will call them
+// again to construct its own values:
+//
+//     provider.AddFactory(func(db *DB) *Session {
+//         return db.NewSession()
+//     })
+//
+//     child := provider.NewChild()
+//     var session *Session
+//     err := child.Provide(&session)
+//     // session is specific to child, but the *DB is shared with provider
+//
 // Multiple values of the same type
 //
 // Since provide can only tell what value a Go function is looking for
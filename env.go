@@ -0,0 +1,150 @@
+package provide
+
+import (
+	"encoding"
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// AddEnvConfig adds a rule that constructs the struct type configType points to
+// by reading each of its exported fields from an environment variable.
+// The variable for a field is named after the prefix and the field name in upper snake case,
+// so with the prefix "APP", the field DatabaseURL is read from APP_DATABASE_URL:
+//
+//     type Config struct {
+//         DatabaseURL string
+//         Timeout     time.Duration
+//     }
+//
+//     err := p.AddEnvConfig("APP", (*Config)(nil))
+//
+// Fields may be strings, ints, int64s, bools, float64s, time.Durations,
+// or any type whose pointer implements encoding.TextUnmarshaler.
+// Fields whose variables aren't set are left as zero values.
+//
+func (p *Provider) AddEnvConfig(prefix string, configType interface{}) error {
+	p.init()
+
+	typ, err := exampleType(configType)
+	if err != nil {
+		return err
+	}
+	if typ.Kind() != reflect.Struct {
+		return errors.New("AddEnvConfig needs a struct type, not " + typ.String())
+	}
+
+	type envField struct {
+		Index int
+		Name  string
+	}
+
+	var fields []envField
+	N := typ.NumField()
+	for i := 0; i < N; i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if !isEnvType(field.Type) {
+			return errors.New("AddEnvConfig can't read " + typ.String() + "." + field.Name + " of type " + field.Type.String())
+		}
+
+		name := envName(field.Name)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		fields = append(fields, envField{i, name})
+	}
+
+	ruleType := reflect.FuncOf(nil, []reflect.Type{typ, errorType}, false)
+	ruleFn := reflect.MakeFunc(ruleType, func([]reflect.Value) []reflect.Value {
+		config := reflect.New(typ).Elem()
+		for _, field := range fields {
+			s, ok := os.LookupEnv(field.Name)
+			if !ok {
+				continue
+			}
+
+			if err := parseEnv(config.Field(field.Index), s); err != nil {
+				err = errors.New("can't parse " + field.Name + ": " + err.Error())
+				return []reflect.Value{config, reflect.ValueOf(&err).Elem()}
+			}
+		}
+		return []reflect.Value{config, reflect.Zero(errorType)}
+	})
+
+	return p.addRule(rule{Fn: ruleFn.Interface(), IsGenerated: true})
+}
+
+func isEnvType(t reflect.Type) bool {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) || t == durationType {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Bool, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func parseEnv(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		v.SetInt(int64(d))
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	}
+	return nil
+}
+
+// envName converts a Go field name to upper snake case,
+// keeping initialisms together: DatabaseURL becomes DATABASE_URL.
+func envName(field string) string {
+	runes := []rune(field)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
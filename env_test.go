@@ -0,0 +1,44 @@
+package provide_test
+
+import (
+	"github.com/MatthewValentine/provide"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEnvConfig(t *testing.T) {
+	t.Setenv("KRUSTY_DATABASE_URL", "postgres://krab")
+	t.Setenv("KRUSTY_TIMEOUT", "3s")
+	t.Setenv("KRUSTY_MAX_CONNS", "12")
+	t.Setenv("KRUSTY_OPEN", "true")
+	t.Setenv("KRUSTY_LISTEN_IP", "127.0.0.1")
+
+	p := &provide.Provider{}
+	err := p.AddEnvConfig("KRUSTY", (*KrustyConfig)(nil))
+	assert(t, err == nil, err)
+
+	var config KrustyConfig
+	err = p.Provide(&config)
+	assert(t, err == nil, err)
+	assert(t, config.DatabaseURL == "postgres://krab", config)
+	assert(t, config.Timeout == 3*time.Second, config)
+	assert(t, config.MaxConns == 12, config)
+	assert(t, config.Open, config)
+	assert(t, config.Price == 0, config)
+	assert(t, config.ListenIP.Equal(net.IPv4(127, 0, 0, 1)), config)
+
+	err = p.AddEnvConfig("", (*struct{ Ch chan int })(nil))
+	assert(t, err != nil)
+}
+
+type KrustyConfig struct {
+	DatabaseURL string
+	Timeout     time.Duration
+	MaxConns    int
+	Open        bool
+	Price       float64
+	ListenIP    net.IP
+
+	secret string
+}
@@ -0,0 +1,207 @@
+package provide
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Freeze makes a Provider read-only. From then on, Provide only returns
+// values that have already been constructed, returning an error for anything else,
+// and adding rules or values returns an error.
+//
+// A frozen Provider never changes, so it's safe to use from multiple goroutines at once:
+//
+//     err := p.Provide(&server, &db) // construct everything that will be needed
+//     p.Freeze()
+//     go handle(p)
+//     go handle(p)
+//
+// Freeze is not itself safe to call while the Provider is being used.
+//
+func (p *Provider) Freeze() {
+	p.init()
+	p.frozen = true
+}
+
+// IsFrozen reports whether Freeze has been called.
+func (p *Provider) IsFrozen() bool {
+	return p.frozen
+}
+
+// Clone returns a copy of a Provider, with the same rules, configuration,
+// and already-constructed values. The copy is independent of the original:
+// constructing values or adding rules in one has no effect on the other,
+// though the values they already share are the same values, and a Provider's parent
+// (see NewChild) stays shared. The copy is never frozen, even if the original is (see Freeze).
+//
+// Clone only reads from the original, so cloning a frozen Provider is safe
+// even while other goroutines are using it, and several clones of it can be taken at once.
+// Cloning a Provider that isn't frozen is only safe while nothing else is using it,
+// unless it's concurrent-safe (see SetConcurrentSafe). The copy is concurrent-safe if the original is.
+//
+func (p *Provider) Clone() *Provider {
+	return p.clone(true)
+}
+
+// clone copies a Provider (see Clone). Unless constructed is set,
+// the copy only keeps the values that were added to p directly, not the ones p constructed.
+func (p *Provider) clone(constructed bool) *Provider {
+	p.init()
+	if p.concurrentSafe {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+	}
+
+	c := &Provider{
+		parent:          p.parent,
+		shared:          append(p.shared[:0:0], p.shared...),
+		sealed:          p.sealed,
+		strict:          p.strict,
+		transactional:   p.transactional,
+		scope:           p.scope,
+		onMissingRule:   p.onMissingRule,
+		concurrentSafe:  p.concurrentSafe,
+		transformer:     p.transformer,
+		slogIntegration: p.slogIntegration,
+		tracer:          p.tracer,
+		defaultTag:      p.defaultTag,
+		maxDepth:        p.maxDepth,
+		parallel:        p.parallel,
+		parallelism:     p.parallelism,
+		middlewares:     append(p.middlewares[:0:0], p.middlewares...),
+		onWarning:       append(p.onWarning[:0:0], p.onWarning...),
+		onError:         append(p.onError[:0:0], p.onError...),
+		scopedRules:     append(p.scopedRules[:0:0], p.scopedRules...),
+		seeded:          append(p.seeded[:0:0], p.seeded...),
+	}
+	c.init()
+	if constructed {
+		c.history = append(p.history[:0:0], p.history...)
+		c.metrics = p.metrics
+	}
+
+	for typ, prerequisites := range p.prerequisites {
+		c.prerequisites[typ] = append(prerequisites[:0:0], prerequisites...)
+	}
+
+	// Rules are installed again, rather than copying their tasks,
+	// since some rules are wrapped in functions that refer to their Provider.
+	for _, r := range p.rules {
+		// The rule was already added to p, so it can be added to c the same way.
+		initializers, _ := c.ruleInitializers(r)
+		c.install(r, initializers)
+	}
+	for t, s := range p.tasks {
+		if s.Done && (constructed || containsType(p.seeded, t.Type)) {
+			c.tasks[t] = s
+			// Both tasks for a type are done once it has a value.
+			if v, ok := p.values[t.Type]; ok {
+				c.values[t.Type] = v
+			}
+		}
+	}
+	for typ, hooks := range p.hooks {
+		c.hooks[typ] = append(hooks[:0:0], hooks...)
+	}
+	for typ := range p.annotations {
+		c.annotations[typ] = p.annotationsFor(typ)
+	}
+	return c
+}
+
+// WithValues returns a copy of the Provider (see Clone) with vals overriding
+// however it would otherwise provide their types. This makes it easy to set up
+// a Provider for a test from the one an application uses:
+//
+//     p := app.WithValues(&fakeClock{}, func() *DB { return testDB })
+//
+// Functions are added as rules, and anything else is added as the value for its dynamic type,
+// like AddValue. Either way, any rule the Provider had for the same types is replaced.
+// Since it's meant for tests, WithValues panics if a value can't be added.
+//
+func (p *Provider) WithValues(vals ...interface{}) *Provider {
+	c := p.Clone()
+	if err := c.seedOverrides("WithValues", vals); err != nil {
+		panic(err.Error())
+	}
+	return c
+}
+
+// NewWithSeed returns a copy of the Provider with the same rules, configuration,
+// and values added to it directly, but none of the values it has constructed,
+// with vals overriding however it would otherwise provide their types, like WithValues:
+//
+//     testP, err := appP.NewWithSeed(mockDB, mockCache)
+//
+// Since nothing has been constructed in the copy yet, everything that depends
+// on the overridden types uses the new values.
+// It returns an error, rather than panicking, if a value can't be added.
+//
+func (p *Provider) NewWithSeed(vals ...interface{}) (*Provider, error) {
+	c := p.clone(false)
+	if err := c.seedOverrides("NewWithSeed", vals); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// seedOverrides adds values and rules that replace however the Provider
+// would otherwise provide their types (see WithValues).
+func (p *Provider) seedOverrides(method string, vals []interface{}) error {
+	// Overrides are allowed even if p is sealed (see SealRules), and it stays sealed.
+	sealed := p.sealed
+	p.sealed = false
+	defer func() { p.sealed = sealed }()
+
+	for _, val := range vals {
+		v := reflect.ValueOf(val)
+		if !v.IsValid() {
+			return errors.New(method + " can't add a nil value without its type")
+		}
+
+		var err error
+		if v.Kind() == reflect.Func {
+			for _, out := range ruleOutputs(val) {
+				p.override(out)
+			}
+			err = p.AddRule(val)
+		} else {
+			p.override(v.Type())
+			err = p.AddValue(val)
+		}
+		if err != nil {
+			return errors.New(method + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// override removes any rule for a type, or value for it, so it can be provided another way.
+// A rule with other outputs is removed entirely.
+func (p *Provider) override(typ reflect.Type) {
+	types := []reflect.Type{typ}
+	for i, r := range p.rules {
+		if outs := r.outputs(); containsType(outs, typ) {
+			types = outs
+			p.rules = append(p.rules[:i:i], p.rules[i+1:]...)
+			p.indexRules()
+			break
+		}
+	}
+
+	seeded := p.seeded[:0:0]
+	for _, t := range p.seeded {
+		if !containsType(types, t) {
+			seeded = append(seeded, t)
+		}
+	}
+	p.seeded = seeded
+
+	for _, t := range types {
+		delete(p.tasks, task{t, false})
+		delete(p.tasks, task{t, true})
+		delete(p.values, t)
+		delete(p.factories, t)
+		delete(p.prototypes, t)
+	}
+}
@@ -0,0 +1,201 @@
+package provide_test
+
+import (
+	"errors"
+	"github.com/MatthewValentine/provide"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFreezeAndClone(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	p.Freeze()
+	assert(t, p.IsFrozen())
+
+	errs := make(chan error, 4*2)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var again *Spongebob
+			err := p.Provide(&again)
+			if err == nil && again != sp {
+				err = errors.New("the frozen Provider constructed another *Spongebob")
+			}
+			errs <- err
+
+			c := p.Clone()
+			var squid Squidward
+			errs <- c.Provide(&squid)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert(t, err == nil, err)
+	}
+
+	var squid Squidward
+	err = p.Provide(&squid)
+	assert(t, err != nil, "Squidward was never constructed before Freeze")
+	err = p.AddRule(func() *Patrick { return &Patrick{} })
+	assert(t, err != nil, "can't add rules after Freeze")
+
+	c := p.Clone()
+	assert(t, !c.IsFrozen())
+	err = c.AddRule(func() *Patrick { return &Patrick{} })
+	assert(t, err == nil, err)
+	var pt *Patrick
+	err = p.Provide(&pt)
+	assert(t, err != nil, "the rule was only added to the clone")
+}
+
+func TestWithValues(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+	p.SealRules()
+
+	c := p.WithValues(func() KrabbyPatty { return "chum" }, Squidward{})
+	var kp KrabbyPatty
+	err = c.Provide(&kp)
+	assert(t, err == nil && kp == "chum", kp, err)
+	var squid Squidward
+	err = c.Provide(&squid)
+	assert(t, err == nil, err)
+
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "jabberwocky", kp, err)
+
+	c = p.WithValues(KrabbyPatty("plankton"))
+	err = c.Provide(&kp)
+	assert(t, err == nil && kp == "plankton", kp, err)
+	err = c.AddValue(Squidward{})
+	assert(t, err != nil, "the copy is still sealed")
+}
+
+func TestCloneIndependentState(t *testing.T) {
+	var calls int32
+	p, err := provide.NewProvider(func() *Spongebob {
+		atomic.AddInt32(&calls, 1)
+		return &Spongebob{}
+	})
+	assert(t, err == nil, err)
+	c := p.Clone()
+
+	// Each Provider runs the rule itself, concurrently, without sharing any state.
+	sps := make([]*Spongebob, 2)
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i, p := range []*provide.Provider{p, c} {
+		wg.Add(1)
+		go func(i int, p *provide.Provider) {
+			defer wg.Done()
+			errs[i] = p.Provide(&sps[i])
+		}(i, p)
+	}
+	wg.Wait()
+	assert(t, errs[0] == nil && errs[1] == nil, errs)
+	assert(t, atomic.LoadInt32(&calls) == 2, calls)
+	assert(t, sps[0] != sps[1], "each Provider has its own *Spongebob")
+}
+
+func TestSetConcurrentSafe(t *testing.T) {
+	var calls int32
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		atomic.AddInt32(&calls, 1)
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+	p.SetConcurrentSafe(true)
+	child := p.NewChild()
+	child.SetConcurrentSafe(true)
+
+	type group struct {
+		Patty KrabbyPatty `provide:""`
+	}
+
+	errs := make(chan error, 8*5)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var sp *Spongebob
+			var kp KrabbyPatty
+			err := p.Provide(&sp, &kp)
+			if err == nil && sp.Patty != kp {
+				err = errors.New("the *Spongebob has another KrabbyPatty")
+			}
+			errs <- err
+
+			errs <- child.ProvideInto(func(kp KrabbyPatty) {})
+			errs <- p.Hydrate(&Spongebob{})
+			var g *group
+			errs <- p.ProvideGroup(&g)
+			if i%2 == 0 {
+				c := p.Clone()
+				errs <- c.Provide(&sp)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert(t, err == nil, err)
+	}
+	assert(t, atomic.LoadInt32(&calls) == 1, calls)
+	assert(t, p.LastError() == nil, p.LastError())
+}
+
+func TestCloneMultiOutputRule(t *testing.T) {
+	calls := 0
+	p, err := provide.NewProvider(func() (KrabbyPatty, UnderSea) {
+		calls++
+		return "jabberwocky", Patrick{}
+	})
+	assert(t, err == nil, err)
+	c := p.Clone()
+
+	var kp KrabbyPatty
+	var us UnderSea
+	err = c.Provide(&kp, &us)
+	assert(t, err == nil && calls == 1, calls, err)
+
+	// Running the rule in the clone doesn't make the original think it has already run.
+	err = p.Provide(&us)
+	assert(t, err == nil && us != nil && calls == 2, us, calls, err)
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "jabberwocky" && calls == 2, kp, calls, err)
+}
+
+func TestNewWithSeed(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+	err = p.AddValue(Squidward{})
+	assert(t, err == nil, err)
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+
+	c, err := p.NewWithSeed(KrabbyPatty("chum"))
+	assert(t, err == nil, err)
+	var fresh *Spongebob
+	var squid Squidward
+	err = c.Provide(&fresh, &squid)
+	assert(t, err == nil && fresh != sp && fresh.Patty == "chum", fresh, err)
+
+	// WithValues keeps what was already constructed.
+	var kept *Spongebob
+	err = p.WithValues(KrabbyPatty("chum")).Provide(&kept)
+	assert(t, err == nil && kept == sp, kept, err)
+
+	_, err = p.NewWithSeed(nil)
+	assert(t, err != nil, "nil has no type")
+}
@@ -0,0 +1,258 @@
+package provide
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MarshalDependencyGraph describes the Provider's rules as JSON, mapping the name
+// of each type a rule outputs to the names of the rule's inputs:
+//
+//     {"*app.Server":["*app.DB","app.Config"],"app.Config":[]}
+//
+// The types provided by prototype rules (see AddPrototype) depend on the prototype functions themselves.
+// Types that are automatically provided, values that don't come from rules,
+// and rules for other scopes (see AddScopedRule) are not included. Everything is sorted, so the output is stable enough
+// to commit and diff to catch unintended changes to an application's dependencies.
+//
+func (p *Provider) MarshalDependencyGraph() ([]byte, error) {
+	p.init()
+
+	graph := make(map[string][]string)
+	for _, r := range p.rules {
+		for _, out := range r.outputs() {
+			graph[out.String()] = sortedNames(p.ruleInputs(r, out))
+		}
+	}
+	return json.Marshal(graph)
+}
+
+// ruleInputs returns the types a type output by a rule is constructed from,
+// as described by MarshalDependencyGraph, Manifest and Inspect:
+// the rule's inputs, or the prototype function for a type provided by a prototype (see AddPrototype).
+func (p *Provider) ruleInputs(r rule, out reflect.Type) []reflect.Type {
+	if fnType, ok := p.prototypes[out]; ok && r.IsPrototype {
+		return []reflect.Type{fnType}
+	}
+
+	t := reflect.TypeOf(r.Fn)
+	ins := make([]reflect.Type, t.NumIn())
+	for i := range ins {
+		ins[i] = t.In(i)
+	}
+	return ins
+}
+
+// sortedNames returns the names of types, sorted.
+func sortedNames(types []reflect.Type) []string {
+	names := make([]string, len(types))
+	for i, typ := range types {
+		names[i] = typ.String()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TypeGraph returns the dependencies between the types the Provider knows how to provide so far,
+// as a map from each type to the types it depends on directly,
+// for use with graph algorithms outside this package:
+//
+//     graph := p.TypeGraph()
+//     for _, dep := range graph[reflect.TypeOf((*Server)(nil))] {
+//         ...
+//     }
+//
+// This includes the outputs of rules, and types that are automatically provided
+// once they've been needed (or checked, as by Summarize). Each list of dependencies is sorted by type name.
+// Since a map has no order, sort its keys (such as by name) to visit them in a stable order.
+//
+func (p *Provider) TypeGraph() map[reflect.Type][]reflect.Type {
+	p.init()
+
+	graph := make(map[reflect.Type][]reflect.Type)
+	for t, s := range p.tasks {
+		deps, ok := graph[t.Type]
+		if !ok {
+			deps = []reflect.Type{}
+		}
+		for _, dep := range s.DependsOn {
+			if dep.Type != t.Type && !containsType(deps, dep.Type) {
+				deps = append(deps, dep.Type)
+			}
+		}
+		graph[t.Type] = deps
+	}
+	for _, deps := range graph {
+		sort.Slice(deps, func(i, j int) bool {
+			return deps[i].String() < deps[j].String()
+		})
+	}
+	return graph
+}
+
+// FindCycles finds every dependency cycle among the types reachable from
+// the types roots point to, without constructing anything. Each cycle is returned
+// as the ring of types that depend on each other, with the first type depending on the second,
+// and so on, and the last depending on the first.
+//
+// Providing any type in a cycle fails, but Provide only reports the first cycle it runs into.
+// Dependencies through provide:"circular" fields don't form cycles, since they are intentional.
+// Types that can't be provided at all are ignored.
+//
+func (p *Provider) FindCycles(roots ...interface{}) ([][]reflect.Type, error) {
+	p.init()
+
+	var nodes []task
+	index := make(map[task]int)
+	edges := make(map[task][]task)
+	visit := func(t task) {
+		if _, ok := index[t]; !ok {
+			index[t] = len(nodes)
+			nodes = append(nodes, t)
+		}
+	}
+	for _, root := range roots {
+		typ, err := exampleType(root)
+		if err != nil {
+			return nil, err
+		}
+		visit(task{typ, true})
+	}
+	for i := 0; i < len(nodes); i++ {
+		s, err := p.peek(nodes[i])
+		if err != nil || s.Done {
+			continue
+		}
+		edges[nodes[i]] = s.DependsOn
+		for _, dep := range s.DependsOn {
+			visit(dep)
+		}
+	}
+
+	var cycles [][]reflect.Type
+	seen := make(map[string]bool)
+	for start := range nodes {
+		// Only look for cycles whose earliest node is start,
+		// so that each one is found exactly once.
+		var path []task
+		onPath := make(map[task]bool)
+		var search func(t task)
+		search = func(t task) {
+			path = append(path, t)
+			onPath[t] = true
+			for _, dep := range edges[t] {
+				if dep == nodes[start] {
+					if cycle := cycleTypes(path); !seen[typeNames(cycle)] {
+						seen[typeNames(cycle)] = true
+						cycles = append(cycles, cycle)
+					}
+				} else if index[dep] > start && !onPath[dep] {
+					search(dep)
+				}
+			}
+			path = path[:len(path)-1]
+			onPath[t] = false
+		}
+		search(nodes[start])
+	}
+	return cycles, nil
+}
+
+// cycleTypes converts a cycle of tasks to a cycle of types,
+// merging the partial and complete tasks for the same type.
+func cycleTypes(path []task) []reflect.Type {
+	var cycle []reflect.Type
+	for _, t := range path {
+		if len(cycle) == 0 || cycle[len(cycle)-1] != t.Type {
+			cycle = append(cycle, t.Type)
+		}
+	}
+	if len(cycle) > 1 && cycle[0] == cycle[len(cycle)-1] {
+		cycle = cycle[:len(cycle)-1]
+	}
+	return cycle
+}
+
+func typeNames(types []reflect.Type) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return strings.Join(names, " --> ")
+}
+
+// DependencyCount returns the number of types the type target points to depends on directly,
+// and the number it depends on directly or indirectly, without constructing anything.
+// This is useful for keeping track of how complex the setup of a type has become:
+//
+//     direct, transitive, err := p.DependencyCount((**Server)(nil))
+//
+// It returns an error if target, or anything it depends on, can't be provided.
+//
+func (p *Provider) DependencyCount(target interface{}) (direct, transitive int, err error) {
+	p.init()
+
+	typ, err := exampleType(target)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	deps, err := p.typeDeps(typ)
+	if err != nil {
+		return 0, 0, err
+	}
+	all, err := p.allTypeDeps(typ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(deps), len(all), nil
+}
+
+// typeDeps returns the types a type depends on directly.
+func (p *Provider) typeDeps(typ reflect.Type) ([]reflect.Type, error) {
+	var deps []reflect.Type
+	seen := map[reflect.Type]bool{typ: true}
+	for _, complete := range [...]bool{false, true} {
+		s, err := p.peek(task{typ, complete})
+		if err != nil {
+			return nil, err
+		}
+		if s.From != nil {
+			return s.From.typeDeps(typ)
+		}
+
+		for _, dep := range s.DependsOn {
+			if !seen[dep.Type] {
+				seen[dep.Type] = true
+				deps = append(deps, dep.Type)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// allTypeDeps returns the types a type depends on directly or indirectly,
+// in the order they're found.
+func (p *Provider) allTypeDeps(typ reflect.Type) ([]reflect.Type, error) {
+	var all []reflect.Type
+	seen := map[reflect.Type]bool{typ: true}
+	queue := []reflect.Type{typ}
+	for len(queue) > 0 {
+		deps, err := p.typeDeps(queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+
+		for _, dep := range deps {
+			if !seen[dep] {
+				seen[dep] = true
+				all = append(all, dep)
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return all, nil
+}
@@ -0,0 +1,79 @@
+package provide
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Hydrate fills in the fields of an existing struct that the Provider can provide,
+// leaving the rest as they are. v must be a pointer to a struct:
+//
+//     type Handler struct {
+//         DB     *DB     `provide:""`
+//         Cache  *Cache  `provide:"optional"`
+//         Logger *Logger
+//     }
+//
+//     h := &Handler{Logger: logger}
+//     err := p.Hydrate(h)
+//
+// Fields tagged `provide:""` are provided if the Provider has a rule or value for their type,
+// and providing them must succeed. Fields tagged `provide:"optional"` are provided
+// if they can be provided in any way, including automatically, and are left alone otherwise.
+// Fields that are already set (not zero) are never changed.
+// Unlike ProvideGroup, the struct isn't kept in the Provider, and PleaseProvide isn't called.
+//
+func (p *Provider) Hydrate(v interface{}) error {
+	p.init()
+	unlock := p.lock()
+	defer unlock()
+
+	vptr := reflect.ValueOf(v)
+	if vptr.Kind() != reflect.Ptr || vptr.IsNil() || vptr.Elem().Kind() != reflect.Struct {
+		return errors.New("Hydrate must be given a non-nil pointer to a struct")
+	}
+
+	elem := vptr.Elem()
+	N := elem.NumField()
+	for i := 0; i < N; i++ {
+		field := elem.Type().Field(i)
+		tag, ok := field.Tag.Lookup("provide")
+		if !ok || !elem.Field(i).IsZero() || field.PkgPath != "" {
+			continue
+		}
+
+		switch tag {
+		case "optional":
+			if p.check(field.Type) != nil {
+				continue
+			}
+		case "", p.defaultTag:
+			if !p.hasRuleOrValue(field.Type) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		value, err := p.get(field.Type)
+		if err != nil {
+			return errors.New("can't hydrate " + elem.Type().String() + "." + field.Name + ": " + err.Error())
+		}
+		elem.Field(i).Set(value)
+	}
+	return nil
+}
+
+// hasRuleOrValue reports whether there's a rule or value for a type
+// in the Provider, its ancestors, or the Providers it shares values with.
+func (p *Provider) hasRuleOrValue(typ reflect.Type) bool {
+	if _, ok := p.sharedValue(typ); ok || p.contains(typ) {
+		return true
+	}
+	for ; p != nil; p = p.parent {
+		if p.hasRule(typ) {
+			return true
+		}
+	}
+	return false
+}
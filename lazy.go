@@ -0,0 +1,40 @@
+package provide
+
+import "reflect"
+
+// A LazySingleton is a handle to a value of type T that a Provider
+// constructs the first time the value is needed, rather than up front.
+//
+// Passing a LazySingleton instead of the value itself lets code depend on
+// a value without forcing it to be constructed first, which avoids
+// problems with the order things are initialized in.
+//
+type LazySingleton[T any] struct {
+	p *Provider
+}
+
+// Lazy returns a LazySingleton that gets its value from p.
+func Lazy[T any](p *Provider) LazySingleton[T] {
+	return LazySingleton[T]{p}
+}
+
+// Get provides the value, constructing it if the Provider hasn't yet.
+// Since a Provider keeps the values it constructs, every call returns the same value.
+func (l LazySingleton[T]) Get() (T, error) {
+	var value T
+	err := l.p.Provide(&value)
+	return value, err
+}
+
+// GetOrZero returns p's value of type T if p has already constructed it,
+// or the zero value of T if it hasn't. Unlike Provide, GetOrZero never constructs anything,
+// which makes it a cheap way to look up optional dependencies.
+func GetOrZero[T any](p *Provider) T {
+	var zero T
+	if value, ok := p.lookup(reflect.TypeOf(&zero).Elem()); ok {
+		// A nil interface value won't assert to T, but it's the zero value anyway.
+		v, _ := value.Interface().(T)
+		return v
+	}
+	return zero
+}
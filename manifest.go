@@ -0,0 +1,208 @@
+package provide
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+)
+
+// A Manifest describes how a Provider has been set up: the rules it has,
+// and the values that have been added to it directly. Everything is sorted by type name.
+type Manifest struct {
+	Rules  []ManifestRule
+	Values []ManifestValue
+}
+
+// A ManifestRule describes the rule a Provider has for a type.
+type ManifestRule struct {
+	Type        reflect.Type
+	Inputs      []reflect.Type
+	Annotations map[string]string
+}
+
+// A ManifestValue describes a value that was added to a Provider directly,
+// along with any tags it was added with.
+type ManifestValue struct {
+	Type        reflect.Type
+	Annotations map[string]string
+}
+
+// Manifest describes how the Provider has been set up.
+// Types that are automatically provided, and rules for other scopes (see AddScopedRule), are not included.
+// The inputs of a type provided by a prototype (see AddPrototype) are the prototype function it calls.
+func (p *Provider) Manifest() Manifest {
+	p.init()
+
+	var m Manifest
+	for _, r := range p.rules {
+		for _, out := range r.outputs() {
+			m.Rules = append(m.Rules, ManifestRule{
+				Type:        out,
+				Inputs:      p.ruleInputs(r, out),
+				Annotations: p.annotationsFor(out),
+			})
+		}
+	}
+	for _, typ := range p.seeded {
+		m.Values = append(m.Values, ManifestValue{
+			Type:        typ,
+			Annotations: p.annotationsFor(typ),
+		})
+	}
+
+	sort.Slice(m.Rules, func(i, j int) bool {
+		return m.Rules[i].Type.String() < m.Rules[j].Type.String()
+	})
+	sort.Slice(m.Values, func(i, j int) bool {
+		return m.Values[i].Type.String() < m.Values[j].Type.String()
+	})
+	return m
+}
+
+// AnnotateType attaches metadata to a type that has a rule or value in the Provider,
+// which appears in its Manifest, and can be used to group types with GroupBy:
+//
+//     err := p.AnnotateType((**Billing)(nil), map[string]string{"owner": "team-backend", "critical": "true"})
+//
+// Annotations are added to any the type already has, replacing those with the same keys.
+//
+func (p *Provider) AnnotateType(typeExample interface{}, annotations map[string]string) error {
+	p.init()
+
+	typ, err := exampleType(typeExample)
+	if err != nil {
+		return err
+	}
+	if p.frozen {
+		return errors.New("can't annotate types after Freeze")
+	}
+	if !p.hasRule(typ) && !containsType(p.seeded, typ) {
+		return errors.New("can't annotate " + typ.String() + ", since it has no rule or value in this Provider")
+	}
+
+	p.annotate(typ, annotations)
+	return nil
+}
+
+// Tag attaches a single piece of metadata to a type, like AnnotateType.
+// Besides types with a rule or value in the Provider, it can tag types
+// the Provider has found out how to provide automatically:
+//
+//     err := p.Tag((**Billing)(nil), "owner", "team-backend")
+//
+// Tags appear in the Provider's Manifest (for types with rules or values) and in Inspect.
+//
+func (p *Provider) Tag(typeExample interface{}, key, value string) error {
+	p.init()
+
+	typ, err := exampleType(typeExample)
+	if err != nil {
+		return err
+	}
+	if p.frozen {
+		return errors.New("can't tag types after Freeze")
+	}
+	if _, ok := p.tasks[task{typ, false}]; !ok {
+		return errors.New("can't tag " + typ.String() + ", since the Provider doesn't know how to provide it yet")
+	}
+
+	p.annotate(typ, map[string]string{key: value})
+	return nil
+}
+
+// GroupBy groups the types that have been tagged with a key (see AddTaggedValue and AnnotateType)
+// by the value of the tag. For example, if the databases were added with an "env" tag:
+//
+//     p.GroupBy("env") // {"prod": [*PGDatabase], "dev": [*SQLiteDatabase]}
+//
+// Each group is sorted by type name, and types without the tag aren't included.
+//
+func (p *Provider) GroupBy(tag string) map[string][]reflect.Type {
+	p.init()
+
+	groups := make(map[string][]reflect.Type)
+	for typ, annotations := range p.annotations {
+		if value, ok := annotations[tag]; ok {
+			groups[value] = append(groups[value], typ)
+		}
+	}
+	for _, types := range groups {
+		sort.Slice(types, func(i, j int) bool {
+			return types[i].String() < types[j].String()
+		})
+	}
+	return groups
+}
+
+// annotationsFor returns a copy of the annotations for a type.
+func (p *Provider) annotationsFor(typ reflect.Type) map[string]string {
+	if len(p.annotations[typ]) == 0 {
+		return nil
+	}
+
+	annotations := make(map[string]string, len(p.annotations[typ]))
+	for k, v := range p.annotations[typ] {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// A ProviderState describes what a Provider can provide and what it has provided (see Inspect).
+// Types are described by name, and everything is sorted, so that it can be compared
+// with a copy saved from an earlier run to catch unintended changes to how an application is set up.
+type ProviderState struct {
+	// Rules maps each type a rule outputs to the types the rule takes,
+	// like MarshalDependencyGraph.
+	Rules map[string][]string `json:"rules"`
+
+	// Values are the types that have values, whether added directly or already constructed.
+	Values []string `json:"values"`
+
+	// Pending are the types that the Provider has found out how to provide, but hasn't constructed yet.
+	Pending []string `json:"pending"`
+
+	// Tags maps each type that has been tagged (see Tag and AnnotateType) to its tags.
+	Tags map[string]map[string]string `json:"tags,omitempty"`
+}
+
+// Inspect describes the current state of the Provider, for snapshot testing:
+//
+//     data, err := json.MarshalIndent(p.Inspect(), "", "  ")
+//     // compare data to testdata/provider_state.json
+//
+func (p *Provider) Inspect() ProviderState {
+	p.init()
+
+	state := ProviderState{
+		Rules:   make(map[string][]string),
+		Values:  []string{},
+		Pending: []string{},
+	}
+	for _, r := range p.rules {
+		for _, out := range r.outputs() {
+			state.Rules[out.String()] = sortedNames(p.ruleInputs(r, out))
+		}
+	}
+	for t, s := range p.tasks {
+		if !t.Complete {
+			continue
+		}
+		if _, hasValue := p.values[t.Type]; s.Done && hasValue {
+			state.Values = append(state.Values, t.Type.String())
+		} else if !s.Done {
+			state.Pending = append(state.Pending, t.Type.String())
+		}
+	}
+	sort.Strings(state.Values)
+	sort.Strings(state.Pending)
+
+	for typ := range p.annotations {
+		if annotations := p.annotationsFor(typ); annotations != nil {
+			if state.Tags == nil {
+				state.Tags = make(map[string]map[string]string)
+			}
+			state.Tags[typ.String()] = annotations
+		}
+	}
+	return state
+}
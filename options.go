@@ -0,0 +1,69 @@
+package provide
+
+import (
+	"log/slog"
+	"reflect"
+)
+
+var slogLoggerType = reflect.TypeOf((*slog.Logger)(nil))
+
+// An Option configures a Provider.
+// Options can be given to NewProvider along with its rules.
+type Option func(*Provider)
+
+// WithStrictMode makes a Provider reject anything suspicious about its rules
+// rather than just warning about it (see OnWarning), and makes it
+// validate the provide tags of the types its rules use as soon as the rules are added
+// (see ValidateType).
+//
+// Types can't be provided if they implement error, and rules can't
+// depend on their own outputs, whether in strict mode or not.
+//
+func WithStrictMode() Option {
+	return func(p *Provider) {
+		p.strict = true
+	}
+}
+
+// WithTransactional makes Provide set all of the values it's asked for, or none of them.
+// Normally, if Provide fails partway through, the values it had already provided have been set:
+//
+//     p, err := NewProvider(WithTransactional(), rules...)
+//     var a *A
+//     var b *B
+//     err = p.Provide(&a, &b)
+//     // if this fails, a is still nil, even if it was constructed
+//
+// Anything that was constructed is still kept in the Provider either way.
+//
+func WithTransactional() Option {
+	return func(p *Provider) {
+		p.transactional = true
+	}
+}
+
+// WithSlogIntegration makes the *slog.Logger a Provider provides the default logger
+// (see slog.SetDefault), so that libraries logging with the slog package's functions
+// use the application's logger rather than each one having to depend on it:
+//
+//     p, err := NewProvider(WithSlogIntegration(), func(c Config) *slog.Logger { ... })
+//     err = p.Provide(&server) // if the server needs a *slog.Logger, it's now the default
+//
+// The logger becomes the default whenever it's constructed, and whenever it's provided to Provide,
+// which includes a logger added with AddValue.
+//
+func WithSlogIntegration() Option {
+	return func(p *Provider) {
+		p.slogIntegration = true
+	}
+}
+
+// setDefaultLogger makes a *slog.Logger the default logger (see WithSlogIntegration).
+func (p *Provider) setDefaultLogger(typ reflect.Type, v reflect.Value) {
+	if !p.slogIntegration || typ != slogLoggerType || v.IsNil() {
+		return
+	}
+	if logger := v.Interface().(*slog.Logger); slog.Default() != logger {
+		slog.SetDefault(logger)
+	}
+}
@@ -0,0 +1,275 @@
+package provide
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// RunParallel is like ProvideInto for several functions at once,
+// except that the functions are called concurrently:
+//
+//     err := p.RunParallel(
+//         func(c *FileConfig) error { return c.Load() },
+//         func(c *RemoteConfig) error { return c.Fetch() },
+//     )
+//
+// Everything the functions take is provided up front, before any of them are called,
+// so the Provider itself is never used concurrently.
+// Functions that take any of the same types are called one after another
+// (in the order they were given) rather than concurrently, since they would share those values.
+// The errors from every function that fails are joined together.
+//
+func (p *Provider) RunParallel(fns ...interface{}) error {
+	p.init()
+
+	vs := make([]reflect.Value, len(fns))
+	inputs := make([][]reflect.Value, len(fns))
+	unlock := p.lock()
+	for i, fn := range fns {
+		v := reflect.ValueOf(fn)
+		if v.Kind() != reflect.Func || v.IsNil() {
+			unlock()
+			return errors.New("RunParallel must be given non-nil functions, but argument " + strconv.Itoa(i+1) + " is not one")
+		}
+		vs[i] = v
+
+		t := v.Type()
+		inputs[i] = make([]reflect.Value, t.NumIn())
+		for j := range inputs[i] {
+			value, err := p.get(t.In(j))
+			if err != nil {
+				unlock()
+				return err
+			}
+			inputs[i][j] = value
+		}
+	}
+	unlock()
+
+	groups := parallelGroups(vs)
+	errs := make([]error, len(fns))
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group []int) {
+			defer wg.Done()
+			for _, i := range group {
+				errs[i] = call(vs[i], inputs[i])
+			}
+		}(group)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// parallelGroups groups the indexes of functions that share any input types,
+// keeping each group in its original order.
+func parallelGroups(vs []reflect.Value) [][]int {
+	parent := make([]int, len(vs))
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	owner := make(map[reflect.Type]int)
+	for i, v := range vs {
+		parent[i] = i
+		t := v.Type()
+		for j := 0; j < t.NumIn(); j++ {
+			if k, ok := owner[t.In(j)]; ok {
+				parent[find(i)] = find(k)
+			} else {
+				owner[t.In(j)] = i
+			}
+		}
+	}
+
+	var groups [][]int
+	index := make(map[int]int)
+	for i := range vs {
+		root := find(i)
+		if _, ok := index[root]; !ok {
+			index[root] = len(groups)
+			groups = append(groups, nil)
+		}
+		groups[index[root]] = append(groups[index[root]], i)
+	}
+	return groups
+}
+
+// SetParallelism makes a Provider run up to n rules at the same time
+// when constructing values, or any number of them if n is 0.
+// Setting n to 1 goes back to running one rule at a time, in order, which is the default.
+//
+// Only rules that don't depend on each other, directly or indirectly, run concurrently,
+// and only once everything they depend on has been constructed.
+// The Provider itself still isn't safe to use from multiple goroutines at once;
+// it's the rules it runs (and any middleware, see AddMiddleware) that must be.
+// Rules that use the Provider themselves, such as weak and recursive rules,
+// and values shared from a parent Provider, are always constructed one at a time.
+//
+func (p *Provider) SetParallelism(n int) {
+	p.parallel = (n != 1)
+	p.parallelism = n
+}
+
+// completeParallel is like complete, but runs independent tasks concurrently.
+func (p *Provider) completeParallel(typ reflect.Type) error {
+	if err := p.check(typ); err != nil {
+		return err
+	}
+
+	// Find every task that still needs to be done, in the order they'd be done one at a time.
+	var pending []task
+	seen := make(map[task]bool)
+	var collect func(t task) error
+	collect = func(t task) error {
+		if seen[t] {
+			return nil
+		}
+		seen[t] = true
+
+		s, err := p.state(t)
+		if err != nil || s.Done {
+			return err
+		}
+		for _, dep := range s.DependsOn {
+			if err := collect(dep); err != nil {
+				return err
+			}
+		}
+		pending = append(pending, t)
+		if !t.Complete {
+			// Like complete, finish what's been started.
+			return collect(task{t.Type, true})
+		}
+		return nil
+	}
+	if err := collect(task{typ, true}); err != nil {
+		return err
+	}
+
+	for len(pending) > 0 {
+		if p.cancelled.CompareAndSwap(true, false) {
+			return errors.New("cancelled by CancelInitialization")
+		}
+
+		var batch, rest []task
+		claimed := make(map[reflect.Type]bool)
+		progress := false
+		for _, t := range pending {
+			s := p.tasks[t]
+			switch {
+			case s.Done:
+				// Done along the way by an exclusive task.
+				progress = true
+
+			case !p.ready(s) || (p.parallelism > 0 && len(batch) == p.parallelism) || claimed[t.Type]:
+				rest = append(rest, t)
+
+			case s.Do == nil || s.Exclusive:
+				// This doesn't run concurrently, so do it now, before the batch starts.
+				if s.Do != nil {
+					if err := p.run(t, s, p.values); err != nil {
+						p.failed[t.Type] = true
+						return err
+					}
+				}
+				if err := p.finish(t, s); err != nil {
+					return err
+				}
+				progress = true
+
+			default:
+				outputs := s.Outputs
+				if len(outputs) == 0 {
+					outputs = []reflect.Type{t.Type}
+				}
+				for _, out := range outputs {
+					claimed[out] = true
+				}
+				batch = append(batch, t)
+			}
+		}
+
+		// Exclusive tasks may have done some of the batch already.
+		todo := batch[:0]
+		for _, t := range batch {
+			if p.tasks[t].Done {
+				progress = true
+			} else {
+				todo = append(todo, t)
+			}
+		}
+		if len(todo) == 0 && !progress {
+			return errors.New("should never happen: no task is ready to be done for " + typ.String())
+		}
+
+		// Each task gets its own copy of the values so far, which is merged back afterwards.
+		valueSets := make([]map[reflect.Type]reflect.Value, len(todo))
+		errs := make([]error, len(todo))
+		var wg sync.WaitGroup
+		for i, t := range todo {
+			valueSets[i] = make(map[reflect.Type]reflect.Value, len(p.values)+1)
+			for typ, v := range p.values {
+				valueSets[i][typ] = v
+			}
+
+			wg.Add(1)
+			go func(i int, t task, s state) {
+				defer wg.Done()
+				errs[i] = p.run(t, s, valueSets[i])
+			}(i, t, p.tasks[t])
+		}
+		wg.Wait()
+
+		for i, t := range todo {
+			if errs[i] != nil {
+				p.failed[t.Type] = true
+				return errs[i]
+			}
+			for typ, v := range valueSets[i] {
+				if _, ok := p.values[typ]; !ok {
+					p.values[typ] = v
+				}
+			}
+			if err := p.finish(t, p.tasks[t]); err != nil {
+				return err
+			}
+		}
+		pending = rest
+	}
+	return nil
+}
+
+// ready reports whether everything a task depends on has been done.
+func (p *Provider) ready(s state) bool {
+	for _, dep := range s.DependsOn {
+		if !p.tasks[dep].Done {
+			return false
+		}
+	}
+	return true
+}
+
+// finish records that a task has been done.
+func (p *Provider) finish(t task, s state) error {
+	if s.Do != nil {
+		p.history = append(p.history, step{t, s})
+	}
+	s.Done = true
+	s.Do = nil
+	p.tasks[t] = s
+	p.doneWith(t, s)
+
+	if t.Complete {
+		return p.postConstruct(t.Type)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package provide
+
+import (
+	"errors"
+	"plugin"
+	"reflect"
+	"strconv"
+)
+
+// Expand loads a Go plugin (see the plugin package) and adds the rules it exports.
+// The plugin must export a variable named ProvideRules holding its rule functions:
+//
+//     // in the plugin's main package
+//     var ProvideRules = []interface{}{
+//         NewStorage,
+//         func(s *Storage) Store { return s },
+//     }
+//
+// Each rule is added as if by AddRule, but if any of them can't be added,
+// none of them are, and the Provider is left as it was.
+//
+func (p *Provider) Expand(pluginPath string) error {
+	plug, err := plugin.Open(pluginPath)
+	if err != nil {
+		return err
+	}
+
+	sym, err := plug.Lookup("ProvideRules")
+	if err != nil {
+		return errors.New("plugin " + pluginPath + " doesn't export ProvideRules")
+	}
+
+	rules, ok := sym.(*[]interface{})
+	if !ok {
+		// Exported variables are looked up as pointers to them.
+		t := reflect.TypeOf(sym)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		return errors.New(
+			"plugin " + pluginPath + " exports ProvideRules as " + t.String() + ", but it must be a []interface{} variable",
+		)
+	}
+
+	p.init()
+	added := make([]rule, len(*rules))
+	for i, fn := range *rules {
+		added[i] = rule{Fn: fn}
+	}
+	if i, err := p.addRules(added); err != nil {
+		return errors.New("plugin " + pluginPath + " rule " + strconv.Itoa(i+1) + ": " + err.Error())
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package provide
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // A Provider is a dependency injector.
@@ -22,109 +26,1494 @@ import (
 // an error instead of successfully constructing the required value.
 //
 type Provider struct {
-	tasks  map[task]state
-	values map[reflect.Type]reflect.Value
+	tasks         map[task]state
+	values        map[reflect.Type]reflect.Value
+	factories     map[reflect.Type]interface{}
+	parent        *Provider
+	shared        []*Provider
+	sealed        bool
+	frozen        bool
+	strict        bool
+	transactional bool
+	scope         string
+
+	defaultTag  string
+	maxDepth    int
+	middlewares []func(reflect.Type, func() error) error
+	hooks       map[reflect.Type][]reflect.Value
+	onWarning   []func(string)
+	onError     []func(reflect.Type, error)
+	trace       []TraceEntry
+	history     []step
+	rules       []rule
+	scopedRules []rule
+	seeded      []reflect.Type
+	annotations map[reflect.Type]map[string]string
+	failed      map[reflect.Type]bool
+	metrics     Metrics
+	lastErr     error
+	parallel    bool
+	parallelism int
+	traceMu     sync.Mutex
+	cancelled   atomic.Bool
+
+	prerequisites  map[reflect.Type][]reflect.Type
+	onMissingRule  func(reflect.Type) error
+	concurrentSafe bool
+	mu             sync.RWMutex
+	transformer    func(reflect.Type, reflect.Value) reflect.Value
+
+	slogIntegration bool
+	prototypes      map[reflect.Type]reflect.Type
+	tracer          Tracer
+	spans           map[task]openSpan
+	spanCtx         context.Context
+
+	// ruleOf indexes rules by the types they output, and eager lists the types
+	// output by eager rules (see AddEager), so neither needs a search of every rule.
+	ruleOf map[reflect.Type]rule
+	eager  []reflect.Type
 }
 
 // NewProvider constructs a Provider given a list of rules to use to
 // construct values from their dependencies.
 //
-//     p, err := NewProvider(rule1, rule2, ...)
+//     p, err := NewProvider(rule1, rule2, ...)
+//
+// is equivalent to
+//
+//     p := &Provider{}
+//     err := p.AddRule(rule1)
+//     err = p.AddRule(rule2)
+//     ...
+//
+// except that every rule is added even if an earlier one fails,
+// and all of the errors are returned together (see errors.Join).
+//
+// Options may be given along with the rules, and are applied before any rules are added:
+//
+//     p, err := NewProvider(WithStrictMode(), rule1, rule2, ...)
+//
+func NewProvider(provideFns ...interface{}) (*Provider, error) {
+	provider := &Provider{}
+	provider.init()
+	for _, provideFn := range provideFns {
+		if opt, ok := provideFn.(Option); ok {
+			opt(provider)
+		}
+	}
+
+	var errs []error
+	for _, provideFn := range provideFns {
+		if _, ok := provideFn.(Option); ok {
+			continue
+		}
+		if err := provider.AddRule(provideFn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return provider, nil
+}
+
+// CheckTypes checks a set of rules for mistakes without setting up a Provider,
+// such as in an init function or TestMain. It reports every rule that isn't a function,
+// every type that more than one of the rules outputs, and every rule that
+// depends on its own output, all together (see errors.Join).
+//
+// CheckTypes doesn't check whether the rules' dependencies can actually be provided.
+//
+func CheckTypes(rules ...interface{}) error {
+	var errs []error
+	outputs := make(map[reflect.Type]bool)
+	for _, provideFn := range rules {
+		initializers, err := customProvide(provideFn)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, init := range initializers {
+			if outputs[init.Type] {
+				errs = append(errs, errors.New("trying to provide the same type "+init.Type.String()+" in multiple ways"))
+			}
+			outputs[init.Type] = true
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AddRule gives a Provider a way to construct more types.
+// All rules should be added before a Provider is used to provide any values.
+//
+// A rule is any function from dependencies to new values or an error:
+//
+//     provider.AddRule(func(a A, b B, c C) (X, Y, error) {
+//         x := makeX(a, b, c)
+//         y := makeY(a, b, c)
+//         return x, y, nil
+//     })
+//
+// The error return is optional, but any interface value that implements
+// error will be considered to be an error.
+//
+func (p *Provider) AddRule(provideFn interface{}) error {
+	p.init()
+
+	return p.addRule(rule{Fn: provideFn})
+}
+
+// AddPrototype adds a rule that outputs a func() T, which also provides T, as a prototype:
+// everything that depends on T (such as each rule that takes it),
+// and every call to Provide for it, gets a new T from calling the function, rather than sharing one value:
+//
+//     provider.AddPrototype(func(db *DB) func() *Session {
+//         return func() *Session { return db.NewSession() }
+//     })
+//
+// The func() T itself is provided as well, like any other output.
+// Named function types are never prototypes, so they can be provided as values of their own.
+//
+func (p *Provider) AddPrototype(prototypeFn interface{}) error {
+	p.init()
+
+	return p.addRule(rule{Fn: prototypeFn, IsPrototype: true})
+}
+
+// AddChain adds a pipeline of rules, where each rule takes only what the rule before it outputs:
+//
+//     err := p.AddChain(
+//         func() (*Config, error) { ... },
+//         func(config *Config) (*DB, error) { ... },
+//         func(db *DB) *Repository { ... },
+//     )
+//
+// If any rule takes an argument the previous rule doesn't output,
+// AddChain returns an error describing where the chain is broken, and adds none of the rules.
+// The first rule may take anything.
+//
+func (p *Provider) AddChain(fns ...interface{}) error {
+	p.init()
+
+	for i, fn := range fns {
+		if _, err := customProvide(fn); err != nil {
+			return errors.New("chain broken at position " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+		if i == 0 {
+			continue
+		}
+
+		outs := make(map[reflect.Type]bool)
+		for _, out := range ruleOutputs(fns[i-1]) {
+			outs[out] = true
+		}
+		t := reflect.TypeOf(fn)
+		for j := 0; j < t.NumIn(); j++ {
+			if !outs[t.In(j)] {
+				return errors.New(
+					"chain broken at position " + strconv.Itoa(i+1) + ": rule " + strconv.Itoa(i+1) + " expects " + t.In(j).String() +
+						" but rule " + strconv.Itoa(i) + " doesn't produce " + t.In(j).String(),
+				)
+			}
+		}
+	}
+
+	rules := make([]rule, len(fns))
+	for i, fn := range fns {
+		rules[i] = rule{Fn: fn}
+	}
+	_, err := p.addRules(rules)
+	return err
+}
+
+// AddRuleFromStruct adds every exported method of s named Provide followed by the name
+// of what it provides, such as ProvideDB, as a rule, with s as the receiver.
+// This lets related rules be organized into a module type that holds their configuration:
+//
+//     type DatabaseModule struct{ DSN string }
+//
+//     func (m *DatabaseModule) ProvideDB() (*DB, error) { return open(m.DSN) }
+//
+//     err := p.AddRuleFromStruct(&DatabaseModule{DSN: dsn})
+//
+// Methods with pointer receivers are only found if s is a pointer.
+// The rules are added in order of their names, and it's an error if s has none.
+//
+func (p *Provider) AddRuleFromStruct(s interface{}) error {
+	p.init()
+
+	v := reflect.ValueOf(s)
+	if !v.IsValid() {
+		return errors.New("AddRuleFromStruct can't find rules on nil")
+	}
+
+	found := false
+	t := v.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		name := t.Method(i).Name
+		if !strings.HasPrefix(name, "Provide") || name == "Provide" {
+			continue
+		}
+		found = true
+		if err := p.addRule(rule{Fn: v.Method(i).Interface()}); err != nil {
+			return errors.New("can't add " + t.String() + "." + name + " as a rule: " + err.Error())
+		}
+	}
+	if !found {
+		return errors.New(t.String() + " has no methods named like ProvideX to use as rules")
+	}
+	return nil
+}
+
+// AddLazyRule is like AddRule, except that the rule is guaranteed to be called at most once,
+// even when it's run from multiple goroutines (see SetParallelism), and
+// even in copies of the Provider made with Clone, which share its results:
+//
+//     err := p.AddLazyRule(func(c Config) (*Pool, error) {
+//         return dial(c.DatabaseURL)
+//     })
+//
+// If the rule fails, every later attempt to use it fails with the same error.
+//
+func (p *Provider) AddLazyRule(provideFn interface{}) error {
+	p.init()
+	return p.addRule(rule{Fn: provideFn, Lazy: &lazyCall{}})
+}
+
+// AddEager is like AddRule, except that the rule's outputs are constructed
+// (along with everything they depend on) whenever Provide is called, whatever it's called for.
+// Use it for components that need to exist for others to work correctly,
+// even though nothing asks for them directly:
+//
+//     err := p.AddEager(func(r *Registry) *MetricsExporter { ... })
+//     err = p.Provide(&server) // also constructs the *MetricsExporter
+//
+// Eager rules are constructed in the order they were added, before anything Provide was asked for.
+//
+func (p *Provider) AddEager(provideFn interface{}) error {
+	p.init()
+	return p.addRule(rule{Fn: provideFn, IsEager: true})
+}
+
+// AddWithPriority is like AddRule, except that when rules conflict
+// (which would otherwise be an error), the rule with the higher priority wins.
+// Rules added any other way have priority 0, so libraries can add their defaults as usual,
+// and applications can replace them, whichever is added first:
+//
+//     err := p.AddWithPriority(100, func() Clock { return fakeClock{} })
+//
+// A rule replaces every rule it outranks entirely, including their other outputs.
+// A rule that's outranked for any of its outputs isn't added at all,
+// and one that outranks some rules but is outranked by others is an error.
+// Conflicting rules with the same priority are still an error,
+// as is replacing a rule whose outputs have already been constructed.
+//
+func (p *Provider) AddWithPriority(priority int, provideFn interface{}) error {
+	p.init()
+	return p.addRule(rule{Fn: provideFn, Priority: priority})
+}
+
+// AddPrerequisite makes sure the type prerequisiteTypeExample points to is fully constructed
+// before the type outputTypeExample points to starts being constructed,
+// even though the output doesn't depend on it:
+//
+//     err := p.AddPrerequisite((**ORM)(nil), (**Migrations)(nil))
+//     // migrations run before the ORM is set up
+//
+// The output's rule, or its automatic construction, isn't changed otherwise.
+// It's an error if the output has already been constructed.
+//
+func (p *Provider) AddPrerequisite(outputTypeExample, prerequisiteTypeExample interface{}) error {
+	p.init()
+
+	output, err := exampleType(outputTypeExample)
+	if err != nil {
+		return err
+	}
+	prerequisite, err := exampleType(prerequisiteTypeExample)
+	if err != nil {
+		return err
+	}
+	if output == prerequisite {
+		return errors.New(output.String() + " can't be a prerequisite of itself")
+	}
+
+	partial, ok := p.tasks[task{output, false}]
+	if ok && (partial.Done || partial.InProgress) {
+		return errors.New("can't add a prerequisite to " + output.String() + " after it has started being constructed")
+	}
+
+	p.prerequisites[output] = append(p.prerequisites[output], prerequisite)
+	if ok {
+		deps := make([]task, 0, len(partial.DependsOn)+1)
+		partial.DependsOn = append(append(deps, partial.DependsOn...), task{prerequisite, true})
+		p.tasks[task{output, false}] = partial
+	}
+	return nil
+}
+
+// AddFactory is like AddRule, except that the rule is also used to
+// construct fresh values in every child Provider created by NewChild.
+//
+// The Provider the factory is added to treats it like any other rule,
+// so its own value acts as the default singleton. Each child, on the other hand,
+// calls the factory again the first time it needs one of its outputs.
+//
+func (p *Provider) AddFactory(factoryFn interface{}) error {
+	p.init()
+
+	return p.addRule(rule{Fn: factoryFn, IsFactory: true})
+}
+
+// NewChild constructs a Provider that shares the values of p,
+// except for the outputs of factories added with AddFactory,
+// which the child constructs afresh the first time they are needed.
+//
+// Any value that isn't produced by a factory is constructed by (and kept in) p,
+// so singletons stay singletons no matter which child asks for them.
+// Rules added to the child only apply to the child.
+//
+func (p *Provider) NewChild() *Provider {
+	p.init()
+
+	child := &Provider{
+		parent:      p,
+		strict:      p.strict,
+		defaultTag:  p.defaultTag,
+		maxDepth:    p.maxDepth,
+		middlewares: p.middlewares,
+		onWarning:   p.onWarning,
+		onError:     p.onError,
+	}
+	child.init()
+	return child
+}
+
+// AddScopedRule is like AddRule, except that the rule only applies
+// within child Providers created by NewScopedProvider with the same scope.
+// Everywhere else, it's as if the rule hadn't been added.
+//
+//     p.AddScopedRule("request", func(r *http.Request) *Session {
+//         return sessionFor(r)
+//     })
+//
+func (p *Provider) AddScopedRule(scope string, provideFn interface{}) error {
+	p.init()
+	if scope == "" {
+		return errors.New("AddScopedRule needs a non-empty scope")
+	}
+	return p.addRule(rule{Fn: provideFn, Scope: scope})
+}
+
+// NewScopedProvider constructs a child Provider (see NewChild) in which the rules added
+// to p (or its ancestors) with AddScopedRule for the given scope also apply.
+// Values for those rules are constructed by and kept in the child,
+// while rules without a scope still apply, through p, as usual.
+// Rules for other scopes don't apply at all.
+//
+func (p *Provider) NewScopedProvider(scope string) *Provider {
+	child := p.NewChild()
+	child.scope = scope
+	for ancestor := p; ancestor != nil; ancestor = ancestor.parent {
+		for _, r := range ancestor.scopedRules {
+			if r.Scope != scope {
+				continue
+			}
+
+			initializers, err := child.ruleInitializers(r)
+			if err != nil {
+				panic("should never happen: scoped rule is invalid: " + err.Error())
+			}
+			// The rule is no longer scoped as far as the child is concerned.
+			r.Scope = ""
+			child.install(r, initializers)
+		}
+	}
+	return child
+}
+
+// OfScope returns a view of p for the named scope, such as a single tenant
+// in a multi-tenant application. It's the same as NewScopedProvider:
+// rules added with AddScopedRule for that scope apply, rules for other scopes don't,
+// and rules without a scope apply as usual.
+//
+// Automatically provided struct types can also belong to a scope,
+// by tagging any of their fields (usually a blank one) with provide-scope:
+//
+//     type TenantStore struct {
+//         _  struct{} `provide-scope:"tenant-a"`
+//         DB *DB      `provide:""`
+//     }
+//
+//     var store *TenantStore
+//     err := p.OfScope("tenant-a").Provide(&store) // constructed in the view
+//     err = p.Provide(&store)                      // fails, since p isn't in that scope
+//
+func (p *Provider) OfScope(name string) *Provider {
+	return p.NewScopedProvider(name)
+}
+
+// NewRequestScope constructs a child Provider (see NewChild) for handling a single request.
+// The child is seeded with ctx as its context.Context, and with each of seedValues
+// as the value for its dynamic type:
+//
+//     func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+//         scope := s.provider.NewRequestScope(r.Context(), w, r)
+//         var handler *Handler
+//         if err := scope.Provide(&handler); err != nil {
+//             ...
+//         }
+//         handler.Handle()
+//     }
+//
+// Factories and rules added to the child can depend on the seeded values,
+// while everything else is still shared with p.
+// NewRequestScope panics if any of seedValues is nil.
+//
+func (p *Provider) NewRequestScope(ctx context.Context, seedValues ...interface{}) *Provider {
+	child := p.NewChild()
+	child.seed(contextType, reflect.ValueOf(ctx))
+	for _, seedValue := range seedValues {
+		v := reflect.ValueOf(seedValue)
+		if !v.IsValid() {
+			panic("NewRequestScope can't be seeded with a nil value")
+		}
+		child.seed(v.Type(), v)
+	}
+	return child
+}
+
+// BindContext adds ctx.Value(contextKey) to a Provider as the value for
+// the type targetTypeExample points to. targetTypeExample is only used for its type,
+// so it's usually a nil pointer:
+//
+//     err := p.BindContext((*UserID)(nil), ctx, userIDKey)
+//
+// This allows values stored in a context.Context to be provided even when the key
+// used to store them isn't accessible, such as a key private to another package
+// that only exports its value type.
+// BindContext returns an error if there is no such value in ctx, or if
+// the value isn't assignable to the target type.
+//
+func (p *Provider) BindContext(targetTypeExample interface{}, ctx context.Context, contextKey interface{}) error {
+	p.init()
+
+	typ, err := exampleType(targetTypeExample)
+	if err != nil {
+		return err
+	}
+
+	value := ctx.Value(contextKey)
+	if value == nil {
+		return errors.New("the context has no value for the key to bind to " + typ.String())
+	}
+
+	v := reflect.ValueOf(value)
+	if !v.Type().AssignableTo(typ) {
+		return errors.New("the context value for " + typ.String() + " has the wrong type " + v.Type().String())
+	}
+	return p.addValue(typ, v)
+}
+
+// ApplyTo adds all of the rules that have been added to p to other as well,
+// including factories, as if they had been added to other directly.
+// Values that have been added to p rather than constructed by a rule are not copied.
+//
+// This allows each module of an application to set up its own Provider,
+// and then push its rules into the application's Provider:
+//
+//     err := databaseModule.ApplyTo(app)
+//
+// Rules that conflict with the rules other already has are not added,
+// and the errors are returned together (see errors.Join).
+// Lazy rules (see AddLazyRule) are called at most once by each Provider they're applied to,
+// rather than sharing their results as copies made with Clone do.
+//
+func (p *Provider) ApplyTo(other *Provider) error {
+	other.init()
+
+	var errs []error
+	for _, r := range append(p.rules[:len(p.rules):len(p.rules)], p.scopedRules...) {
+		if r.Lazy != nil {
+			r.Lazy = &lazyCall{}
+		}
+		if err := other.addRule(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// BindFunc adds fn to a Provider as the implementation of the interface ifacePtr points to.
+// The interface must have a single method, and fn must have the same signature as the method:
+//
+//     err := p.BindFunc((*http.Handler)(nil), http.HandlerFunc(serveIndex))
+//
+// Go can't create new types with methods at runtime, so fn's type must already
+// implement the interface, usually by being a named function type like http.HandlerFunc
+// whose method calls the function itself. BindFunc reports an error explaining
+// this for a plain function with the right signature.
+//
+func (p *Provider) BindFunc(ifacePtr interface{}, fn interface{}) error {
+	p.init()
+
+	iface, err := exampleType(ifacePtr)
+	if err != nil {
+		return err
+	}
+	if iface.Kind() != reflect.Interface || iface.NumMethod() != 1 {
+		return errors.New("BindFunc needs an interface with a single method, not " + iface.String())
+	}
+
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return errors.New("BindFunc needs a non-nil function to bind to " + iface.String())
+	}
+
+	method := iface.Method(0)
+	if !sameSignature(v.Type(), method.Type) {
+		return errors.New("can't bind " + v.Type().String() + " to " + iface.String() + ", since " + method.Name + " has the signature " + method.Type.String())
+	}
+	if !v.Type().Implements(iface) {
+		return errors.New(
+			"can't bind " + v.Type().String() + " to " + iface.String() + ", since it has no " + method.Name +
+				" method; convert it to a named function type with one (such as http.HandlerFunc for http.Handler)",
+		)
+	}
+	return p.addValue(iface, v)
+}
+
+// AddMiddleware registers a function that wraps every step a Provider takes
+// to construct a value, whether it calls a rule or constructs the value automatically.
+// The middleware is given the type being constructed and a next function
+// that performs the step, and must return the error next returns (or one of its own):
+//
+//     p.AddMiddleware(func(t reflect.Type, next func() error) error {
+//         start := time.Now()
+//         err := next()
+//         log.Println("constructed", t, "in", time.Since(start))
+//         return err
+//     })
+//
+// Middleware composes in registration order, with the first registered as the outermost.
+// A child created by NewChild starts with the middleware of its parent.
+//
+// Middleware that returns without calling next skips the step. If the step
+// would have constructed the value, the zero value is used instead,
+// the same as when a weak rule fails (see AddWeak).
+//
+func (p *Provider) AddMiddleware(fn func(reflect.Type, func() error) error) {
+	p.middlewares = append(p.middlewares, fn)
+}
+
+// AddInterceptor is another name for AddMiddleware, for wrapping every rule
+// with cross-cutting behavior such as tracing spans, retries, or circuit breakers:
+//
+//     p.AddInterceptor(func(t reflect.Type, next func() error) error {
+//         for attempt := 0; ; attempt++ {
+//             err := next()
+//             if err == nil || attempt == 3 {
+//                 return err
+//             }
+//         }
+//     })
+//
+// Interceptors and middleware share one chain, so they compose in the order they were added,
+// and an interceptor that returns without calling next skips the step the same way.
+//
+func (p *Provider) AddInterceptor(fn func(reflect.Type, func() error) error) {
+	p.AddMiddleware(fn)
+}
+
+// AddPostConstruct registers fn to be called with the value of the type typeExample points to
+// right after the Provider finishes constructing it, however it was constructed.
+// fn must be a func(T) or func(T) error, and returning an error makes
+// providing the value fail. Multiple functions for the same type are called
+// in the order they were added:
+//
+//     err := p.AddPostConstruct((**DB)(nil), func(db *DB) error {
+//         return db.Ping()
+//     })
+//
+// Values added to the Provider directly, rather than constructed, are not passed to fn.
+//
+func (p *Provider) AddPostConstruct(typeExample interface{}, fn interface{}) error {
+	p.init()
+
+	typ, err := exampleType(typeExample)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || v.IsNil() || t.NumIn() != 1 || t.In(0) != typ ||
+		t.NumOut() > 1 || (t.NumOut() == 1 && !IsErrorType(t.Out(0))) {
+		return errors.New("post-construct functions for " + typ.String() + " must be a func(" + typ.String() + ") or func(" + typ.String() + ") error")
+	}
+
+	p.hooks[typ] = append(p.hooks[typ], v)
+	return nil
+}
+
+// SetValueTransformer sets a function that's given every value the Provider constructs,
+// once it's fully constructed, and returns the value to keep in its place.
+// It's called before any post-construct functions (see AddPostConstruct):
+//
+//     p.SetValueTransformer(func(t reflect.Type, v reflect.Value) reflect.Value {
+//         registry.Add(t, v.Interface())
+//         return v
+//     })
+//
+// The value returned must be assignable to t. There's only one transformer,
+// so setting another replaces the first; combine them into one function to use several.
+// As with post-construct functions, values added to the Provider directly are not transformed.
+//
+func (p *Provider) SetValueTransformer(fn func(t reflect.Type, v reflect.Value) reflect.Value) {
+	p.transformer = fn
+}
+
+// OnWarning registers a function to be called with a description of anything
+// suspicious about the rules given to a Provider that isn't necessarily an error:
+//
+//     - A rule that takes more than one argument of the same type,
+//       which will always be given the same value.
+//     - A rule that outputs an interface type without selecting one of its inputs
+//       as the implementation, such as func() io.ReadCloser. Since a Provider can only
+//       have one rule for any given type, this prevents the implementation from being
+//       used anywhere as its concrete type. Prefer a rule that outputs the concrete type,
+//       plus a rule that binds it to the interface:
+//
+//           func() *Impl { return &Impl{} }
+//           func(impl *Impl) io.ReadCloser { return impl }
+//
+//     - A weak rule (see AddWeak) that failed.
+//
+// In strict mode (see WithStrictMode), suspicious rules are errors instead.
+//
+func (p *Provider) OnWarning(fn func(warning string)) {
+	p.onWarning = append(p.onWarning, fn)
+}
+
+// SetOnMissingRule sets a function to call when the Provider needs a type
+// it has no rule for and can't automatically provide. The function can add a rule
+// for the type (with AddRule or the like), which is then used as if it had been there all along:
+//
+//     p.SetOnMissingRule(func(t reflect.Type) error {
+//         if client, ok := generatedClients[t]; ok {
+//             return p.AddRule(client)
+//         }
+//         return nil
+//     })
+//
+// If the function returns an error, providing the type fails with that error.
+// If it returns nil without adding a rule, the type can't be provided as usual.
+//
+func (p *Provider) SetOnMissingRule(fn func(t reflect.Type) error) {
+	p.onMissingRule = fn
+}
+
+// OnError registers fn to be called whenever the Provider fails to provide a type,
+// whether because a rule returned an error or because the type (or something it depends on)
+// can't be provided at all. fn is given the type that was asked for and the error,
+// before the error is returned, so failures can be monitored in one place:
+//
+//     p.OnError(func(t reflect.Type, err error) {
+//         metrics.Increment("di.failures", t.String())
+//     })
+//
+// Multiple functions are called in the order they were registered.
+// A child created by NewChild starts with the error functions of its parent.
+//
+func (p *Provider) OnError(fn func(t reflect.Type, err error)) {
+	p.onError = append(p.onError, fn)
+}
+
+// Replay throws away every value the Provider has constructed and constructs them again,
+// using the same rules in the same order as they were originally constructed.
+// Values that were added to the Provider directly, rather than constructed, are kept.
+//
+// This makes it possible to rebuild everything after something the rules depend on
+// has changed, like a runtime flag, without setting up a new Provider.
+// Since the order is already known, Replay doesn't need to resolve any dependencies.
+// Each value goes through SetValueTransformer and AddPostConstruct again once it's complete.
+//
+func (p *Provider) Replay() error {
+	p.init()
+
+	rebuilt := make(map[reflect.Type]bool)
+	recorded := make(map[task]bool)
+	for _, s := range p.history {
+		rebuilt[s.Task.Type] = true
+		recorded[s.Task] = true
+		for _, out := range s.State.Outputs {
+			rebuilt[out] = true
+		}
+	}
+	for typ := range rebuilt {
+		delete(p.values, typ)
+	}
+
+	done := make(map[task]bool)
+	var incomplete []reflect.Type
+	for _, s := range p.history {
+		if err := p.run(s.Task, s.State, p.values); err != nil {
+			return err
+		}
+		done[s.Task] = true
+		if !s.Task.Complete && p.tasks[task{s.Task.Type, true}].Done {
+			incomplete = append(incomplete, s.Task.Type)
+		}
+		for _, out := range s.State.Outputs {
+			if out != s.Task.Type {
+				done[task{out, false}] = true
+				if p.tasks[task{out, true}].Done {
+					incomplete = append(incomplete, out)
+				}
+			}
+		}
+
+		// Most complete tasks aren't in the history, since there's nothing to do for them,
+		// so they're done again as soon as everything they depend on is.
+		for progress := true; progress; {
+			progress = false
+			remaining := incomplete[:0]
+			for _, typ := range incomplete {
+				complete := task{typ, true}
+				ready := done[complete]
+				if !ready && !recorded[complete] {
+					ready = true
+					for _, dep := range p.tasks[complete].DependsOn {
+						if rebuilt[dep.Type] && !done[dep] {
+							ready = false
+							break
+						}
+					}
+				}
+				if !ready {
+					remaining = append(remaining, typ)
+					continue
+				}
+				done[complete] = true
+				if err := p.postConstruct(typ); err != nil {
+					return err
+				}
+				progress = true
+			}
+			incomplete = remaining
+		}
+	}
+	return nil
+}
+
+// SetDefaultTag sets another tag value that marks a field to be automatically provided,
+// in addition to the empty string. After SetDefaultTag("inject"), these are equivalent:
+//
+//     type Foo struct {
+//         Bar *Bar `provide:""`
+//         Baz *Baz `provide:"inject"`
+//     }
+//
+// The tag only affects types that haven't been automatically provided yet,
+// so it should be set before the Provider is used.
+//
+func (p *Provider) SetDefaultTag(s string) {
+	p.defaultTag = s
+}
+
+// SealRules prevents any more rules or values from being added to a Provider.
+// AddRule, AddValue, and the like will return an error from then on,
+// but Provide continues to construct values using the rules already added.
+//
+// Seal a Provider once its configuration is complete, such as at the end of application startup,
+// to make sure nothing changes its rules afterwards.
+//
+func (p *Provider) SealRules() {
+	p.sealed = true
+}
+
+// IsSealedRules reports whether SealRules has been called.
+func (p *Provider) IsSealedRules() bool {
+	return p.sealed
+}
+
+func (p *Provider) addRule(r rule) error {
+	if p.frozen {
+		return errors.New("can't add rules to a Provider after Freeze")
+	}
+	if p.sealed {
+		return errors.New("can't add rules to a Provider after SealRules")
+	}
+
+	initializers, err := p.ruleInitializers(r)
+	if err != nil {
+		return err
+	}
+	if !r.IsGenerated {
+		for _, warning := range ruleWarnings(reflect.TypeOf(r.Fn)) {
+			if err := p.warn(warning); err != nil {
+				return err
+			}
+		}
+	}
+	if p.strict {
+		if err := validateRuleTypes(reflect.TypeOf(r.Fn), p.defaultTag); err != nil {
+			return err
+		}
+	}
+
+	if r.Scope != "" {
+		for _, other := range p.scopedRules {
+			if other.Scope != r.Scope {
+				continue
+			}
+			for _, out := range other.outputs() {
+				for _, init := range initializers {
+					if init.Type == out {
+						return errors.New("trying to provide the same type " + out.String() + " in multiple ways in scope " + r.Scope)
+					}
+				}
+			}
+		}
+		p.scopedRules = append(p.scopedRules, r)
+		return nil
+	}
+
+	if outranked, err := p.prioritize(r); outranked || err != nil {
+		return err
+	}
+	for _, init := range initializers {
+		tasks := [...]task{
+			{init.Type, false},
+			{init.Type, true},
+		}
+		for _, t := range tasks {
+			if _, ok := p.tasks[t]; ok {
+				return errors.New("trying to provide the same type " + t.Type.String() + " in multiple ways")
+			}
+		}
+	}
+
+	p.install(r, initializers)
+	return nil
+}
+
+// addRules adds rules like addRule, but adds none of them if any of them can't be added,
+// returning the index of the one that couldn't along with the error.
+func (p *Provider) addRules(rules []rule) (int, error) {
+	before := p.rulesState()
+	for i, r := range rules {
+		if err := p.addRule(r); err != nil {
+			p.restoreRules(before)
+			return i, err
+		}
+	}
+	return 0, nil
+}
+
+// A rulesState is everything adding rules can change about a Provider (see addRules).
+type rulesState struct {
+	rules       []rule
+	scopedRules []rule
+	seeded      []reflect.Type
+	tasks       map[task]state
+	values      map[reflect.Type]reflect.Value
+	factories   map[reflect.Type]interface{}
+	prototypes  map[reflect.Type]reflect.Type
+}
+
+func (p *Provider) rulesState() rulesState {
+	s := rulesState{
+		rules:       append(p.rules[:0:0], p.rules...),
+		scopedRules: append(p.scopedRules[:0:0], p.scopedRules...),
+		seeded:      append(p.seeded[:0:0], p.seeded...),
+		tasks:       make(map[task]state, len(p.tasks)),
+		values:      make(map[reflect.Type]reflect.Value, len(p.values)),
+		factories:   make(map[reflect.Type]interface{}, len(p.factories)),
+		prototypes:  make(map[reflect.Type]reflect.Type, len(p.prototypes)),
+	}
+	for t, st := range p.tasks {
+		s.tasks[t] = st
+	}
+	for typ, v := range p.values {
+		s.values[typ] = v
+	}
+	for typ, fn := range p.factories {
+		s.factories[typ] = fn
+	}
+	for typ, fnType := range p.prototypes {
+		s.prototypes[typ] = fnType
+	}
+	return s
+}
+
+func (p *Provider) restoreRules(s rulesState) {
+	p.rules = s.rules
+	p.scopedRules = s.scopedRules
+	p.seeded = s.seeded
+	p.tasks = s.tasks
+	p.values = s.values
+	p.factories = s.factories
+	p.prototypes = s.prototypes
+	p.indexRules()
+}
+
+// prioritize compares a rule with the rules it conflicts with (see AddWithPriority),
+// and removes the ones it outranks. It reports whether r is outranked itself, and shouldn't be added.
+func (p *Provider) prioritize(r rule) (bool, error) {
+	var lower, higher []reflect.Type
+	for _, out := range r.outputs() {
+		other, ok := p.ruleFor(out)
+		switch {
+		case !ok || other.Priority == r.Priority:
+		case other.Priority > r.Priority:
+			higher = append(higher, out)
+		default:
+			lower = append(lower, out)
+		}
+	}
+
+	if len(higher) > 0 && len(lower) > 0 {
+		return false, errors.New(
+			"rule outranks the rule for " + lower[0].String() + " but not the rule for " + higher[0].String() +
+				" — split the rule so each output can be prioritized on its own",
+		)
+	}
+	if len(higher) > 0 {
+		return true, nil
+	}
+
+	for _, out := range lower {
+		for _, t := range [...]task{{out, false}, {out, true}} {
+			if s := p.tasks[t]; s.Done || s.InProgress {
+				return false, errors.New("can't replace the rule for " + out.String() + " after it has started being constructed")
+			}
+		}
+	}
+	for _, out := range lower {
+		p.override(out)
+	}
+	return false, nil
+}
+
+func (p *Provider) ruleInitializers(r rule) ([]initializer, error) {
+	if r.Condition != nil {
+		unconditional := r
+		unconditional.Condition = nil
+		initializers, err := p.ruleInitializers(unconditional)
+		for i := range initializers {
+			initializers[i] = p.conditional(initializers[i], r.Condition)
+		}
+		return initializers, err
+	}
+	if r.IsPrototype {
+		plain := r
+		plain.IsPrototype = false
+		initializers, err := p.ruleInitializers(plain)
+		if err != nil {
+			return nil, err
+		}
+		return prototypes(initializers)
+	}
+	if r.Lazy != nil {
+		return customProvide(r.Lazy.wrap(r.Fn))
+	}
+	if !r.IsRecursive {
+		return customProvide(r.Fn)
+	}
+
+	provideFn, err := p.recursive(r.Fn)
+	if err != nil {
+		return nil, err
+	}
+	initializers, err := customProvide(provideFn)
+	for i := range initializers {
+		// Recursive rules provide values themselves as they go.
+		initializers[i].Partial.Exclusive = true
+	}
+	return initializers, err
+}
+
+// install adds a rule that has already been checked.
+func (p *Provider) install(r rule, initializers []initializer) {
+	outputs := make([]reflect.Type, len(initializers))
+	for i, init := range initializers {
+		outputs[i] = init.Type
+	}
+	for _, init := range initializers {
+		if r.IsWeak {
+			init = p.weaken(init, outputs)
+		}
+		p.set(init)
+		if r.IsFactory {
+			p.factories[init.Type] = r.Fn
+		}
+	}
+	if r.IsPrototype {
+		for _, out := range ruleOutputs(r.Fn) {
+			if elem, ok := prototypeOf(out); ok {
+				p.prototypes[elem] = out
+			}
+		}
+	}
+	p.rules = append(p.rules, r)
+	p.index(r)
+}
+
+// Provide, given a set of non-nil pointers, will construct, initialize,
+// and set the values they point to using the rules the Provider has been given.
+//
+// Note that if the value you are trying to get is itself a pointer (and it often is,)
+// you will have to give Provide a pointer-to-pointer:
+//
+//     var aPtr *A
+//     var bInterface B
+//     err := p.Provide(&aPtr, &b)
+//     // aPtr and bInterface are now non-nil
+//
+// Values of non-pointer types are requested the same way, with a pointer to a variable of that type.
+// They use a rule for the value type itself if there is one, and otherwise are
+// copied from the *T the Provider has (or automatically constructs),
+// except for structs that are constructed directly from their provide-tagged fields:
+//
+//     var config Config
+//     err := p.Provide(&config)
+//     // uses a rule for Config, or else dereferences the Provider's *Config
+//
+func (p *Provider) Provide(ptrsToRequests ...interface{}) error {
+	p.init()
+	defer p.doneCancelling()
+
+	if p.concurrentSafe {
+		if p.provideConstructed(ptrsToRequests) {
+			return nil
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+	}
+
+	err := p.provide(ptrsToRequests)
+	if !p.frozen {
+		// A frozen Provider is never changed, so it can be shared between goroutines.
+		p.lastErr = err
+	}
+	return err
+}
+
+// SetConcurrentSafe sets whether a Provider can be used by multiple goroutines at once.
+// When it is, calls to Provide that only need values that have already been constructed
+// share a read lock, and the rest take turns constructing values:
+//
+//     p.SetConcurrentSafe(true)
+//     http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+//         var db *DB
+//         err := p.Provide(&db)
+//         ...
+//     })
+//
+// The same goes for everything built on Provide, ProvideInto, and RunParallel (though not the functions it calls),
+// as well as Hydrate, ProvideGroup and Clone.
+// Adding rules or values, and changing any other configuration, still isn't safe while the Provider is in use.
+// Rules must not call Provide on the Provider that's running them, since it's already locked;
+// use a RecursiveProvider instead (see AddRecursive).
+//
+// A child Provider (see NewChild) locks its parent as well when it needs values from it,
+// but only if the parent is concurrent-safe itself.
+// A frozen Provider (see Freeze) is safe to use concurrently without this.
+//
+func (p *Provider) SetConcurrentSafe(v bool) {
+	p.init()
+	p.concurrentSafe = v
+}
+
+// lock locks a concurrent-safe Provider (see SetConcurrentSafe),
+// returning the function to unlock it. It does nothing for other Providers.
+func (p *Provider) lock() func() {
+	if !p.concurrentSafe {
+		return func() {}
+	}
+	p.mu.Lock()
+	return p.mu.Unlock
+}
+
+// provideConstructed provides requests there are already values for, holding only a read lock.
+// It reports whether it did, or whether Provide needs to do more than that.
+func (p *Provider) provideConstructed(ptrsToRequests []interface{}) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.lastErr != nil {
+		// The error is only cleared by a call that can change it.
+		return false
+	}
+	for _, out := range p.eager {
+		if !p.tasks[task{out, true}].Done {
+			return false
+		}
+	}
+
+	values := make([]reflect.Value, len(ptrsToRequests))
+	for i, ptr := range ptrsToRequests {
+		vptr := reflect.ValueOf(ptr)
+		if vptr.Kind() != reflect.Ptr || vptr.IsNil() {
+			return false
+		}
+		typ := vptr.Elem().Type()
+		value, ok := p.values[typ]
+		if !ok || !p.tasks[task{typ, true}].Done {
+			return false
+		}
+		values[i] = value
+	}
+
+	for i, ptr := range ptrsToRequests {
+		reflect.ValueOf(ptr).Elem().Set(values[i])
+	}
+	return true
+}
+
+// LastError returns the error returned by the most recent call to Provide before Freeze,
+// or nil if it succeeded (or Provide hasn't been called),
+// so a failure can be inspected away from the call that caused it:
+//
+//     if err := p.LastError(); err != nil {
+//         log.Println("startup failed:", err)
+//     }
+//
+func (p *Provider) LastError() error {
+	if p.concurrentSafe {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+	}
+	return p.lastErr
+}
+
+func (p *Provider) provide(ptrsToRequests []interface{}) error {
+	for _, out := range p.eager {
+		if p.frozen {
+			break
+		}
+		if err := p.complete(out); err != nil {
+			return err
+		}
+	}
+
+	targets := make([]reflect.Value, 0, len(ptrsToRequests))
+	values := make([]reflect.Value, 0, len(ptrsToRequests))
+	for _, ptr := range ptrsToRequests {
+		vptr := reflect.ValueOf(ptr)
+		if vptr.Kind() != reflect.Ptr || vptr.IsNil() {
+			return errors.New("arguments to Provide must be non-nil pointers (that Provide will set)")
+		}
+
+		v := vptr.Elem()
+		value, err := p.get(v.Type())
+		if err != nil {
+			return err
+		}
+
+		if p.transactional {
+			targets = append(targets, v)
+			values = append(values, value)
+		} else {
+			v.Set(value)
+		}
+	}
+
+	for i, v := range targets {
+		v.Set(values[i])
+	}
+	return nil
+}
+
+// ProvideMulti provides the types typeExamples point to, returning their values
+// in the same order, for code that handles types it doesn't know statically:
+//
+//     values, err := p.ProvideMulti((**Server)(nil), (*Config)(nil))
+//     server := values[0].(*Server)
+//
+// The types are provided with one call to Provide, which stops at the first one that can't be provided.
+//
+func (p *Provider) ProvideMulti(typeExamples ...interface{}) ([]interface{}, error) {
+	p.init()
+
+	ptrs := make([]interface{}, len(typeExamples))
+	for i, example := range typeExamples {
+		typ, err := exampleType(example)
+		if err != nil {
+			return nil, err
+		}
+		ptrs[i] = reflect.New(typ).Interface()
+	}
+	if err := p.Provide(ptrs...); err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(ptrs))
+	for i, ptr := range ptrs {
+		values[i] = reflect.ValueOf(ptr).Elem().Interface()
+	}
+	return values, nil
+}
+
+// ProvideAs provides the type asTypePtr points to, and sets the variable valuePtr points to,
+// which may have another type the value is assignable to, such as an interface it implements:
+//
+//     var db Database
+//     err := p.ProvideAs(&db, (**PGDatabase)(nil))
+//     // db is the *PGDatabase the Provider constructed
+//
+// This chooses the implementation where it's used, rather than with a rule binding it to the interface.
+// asTypePtr is only used for its type, so it's usually a nil pointer.
+//
+func (p *Provider) ProvideAs(valuePtr, asTypePtr interface{}) error {
+	p.init()
+
+	vptr := reflect.ValueOf(valuePtr)
+	if vptr.Kind() != reflect.Ptr || vptr.IsNil() {
+		return errors.New("ProvideAs must be given a non-nil pointer (that ProvideAs will set)")
+	}
+	typ, err := exampleType(asTypePtr)
+	if err != nil {
+		return err
+	}
+	if v := vptr.Elem(); !typ.AssignableTo(v.Type()) {
+		return errors.New("can't provide " + typ.String() + " as " + v.Type().String() + ", since it isn't assignable to it")
+	}
+
+	value := reflect.New(typ)
+	if err := p.Provide(value.Interface()); err != nil {
+		return err
+	}
+	vptr.Elem().Set(value.Elem())
+	return nil
+}
+
+// ProvideGroup constructs a new struct for the struct pointer structPtr points to,
+// sets it up the same way it would be automatically provided by setting its
+// provide-tagged fields and calling PleaseProvide, and keeps it in the Provider.
+//
+//     var handlers *Handlers
+//     err := p.ProvideGroup(&handlers)
+//
+// Unlike Provide, the struct doesn't need any provide-tagged fields or PleaseProvide method
+// to be constructed this way. This makes ProvideGroup a convenient way to gather a group of
+// values into a single struct. It is an error to use ProvideGroup on a struct that
+// the Provider has a rule for.
+//
+func (p *Provider) ProvideGroup(structPtr interface{}) error {
+	p.init()
+	unlock := p.lock()
+	defer unlock()
+
+	vptr := reflect.ValueOf(structPtr)
+	if vptr.Kind() != reflect.Ptr || vptr.IsNil() || vptr.Elem().Kind() != reflect.Ptr || vptr.Elem().Type().Elem().Kind() != reflect.Struct {
+		return errors.New("ProvideGroup must be given a non-nil pointer to a struct pointer (that ProvideGroup will set)")
+	}
+
+	v := vptr.Elem()
+	typ := v.Type()
+	if p.hasRule(typ) {
+		return errors.New("can't use ProvideGroup for " + typ.String() + " since the Provider has a rule for it")
+	}
+	if _, ok := p.tasks[task{typ, false}]; !ok {
+		init, err := autoProvidePtr(typ, p.defaultTag, true)
+		if err != nil {
+			return err
+		}
+		p.set(init)
+	}
+
+	value, err := p.get(typ)
+	if err != nil {
+		return err
+	}
+
+	v.Set(value)
+	return nil
+}
+
+// CancelInitialization makes a Provide call running in another goroutine
+// stop before it constructs anything else, and return an error.
+// Rules that are already running are not interrupted.
+// If no Provide call is running, the next one is cancelled instead.
+// Either way, the cancellation only applies to that one call: if it has nothing left to construct,
+// it returns as usual, and the call after it isn't cancelled.
+//
+// CancelInitialization is the only method of a Provider that is safe
+// to call concurrently with its other methods.
 //
-// is equivalent to
+func (p *Provider) CancelInitialization() {
+	p.cancelled.Store(true)
+}
+
+// doneCancelling is called at the end of a call that CancelInitialization could cancel,
+// so that a cancellation it didn't use doesn't cancel the next call.
+func (p *Provider) doneCancelling() {
+	p.cancelled.Store(false)
+}
+
+// RegisterType prepares the Provider to automatically provide t, without constructing anything.
+// It checks t's provide tags and PleaseProvide method right away, rather than
+// the first time t is provided, so calling it at startup or in tests catches mistakes early:
 //
-//     p := &Provider{}
-//     err := p.AddRule(rule1)
-//     err = p.AddRule(rule2)
-//     ...
+//     err := p.RegisterType(reflect.TypeOf((*Server)(nil)))
 //
-// aborting if there's a non-nil error.
+// RegisterType does nothing for types the Provider already knows how to provide,
+// such as types it has rules for.
 //
-func NewProvider(provideFns ...interface{}) (*Provider, error) {
-	provider := &Provider{}
-	provider.init()
-	for _, provideFn := range provideFns {
-		if err := provider.AddRule(provideFn); err != nil {
-			return nil, err
+func (p *Provider) RegisterType(t reflect.Type) error {
+	p.init()
+
+	if _, ok := p.tasks[task{t, false}]; ok {
+		return nil
+	}
+	if err := validateType(t, p.defaultTag); err != nil {
+		return err
+	}
+
+	s, err := p.state(task{t, false})
+	if err != nil {
+		return err
+	}
+	for _, dep := range s.DependsOn {
+		// Non-pointer types are usually provided through a pointer,
+		// which is really the type that needs to be checked.
+		if dep.Type == reflect.PtrTo(t) {
+			return p.RegisterType(dep.Type)
 		}
 	}
-	return provider, nil
+	return nil
 }
 
-// AddRule gives a Provider a way to construct more types.
-// All rules should be added before a Provider is used to provide any values.
+// Contains reports whether the Provider has already fully constructed a value for
+// the type typeExample points to, without constructing anything:
 //
-// A rule is any function from dependencies to new values or an error:
+//     if p.Contains((**DB)(nil)) {
+//         // the database connection has been established
+//     }
 //
-//     provider.AddRule(func(a A, b B, c C) (X, Y, error) {
-//         x := makeX(a, b, c)
-//         y := makeY(a, b, c)
-//         return x, y, nil
+func (p *Provider) Contains(typeExample interface{}) bool {
+	typ, err := exampleType(typeExample)
+	if err != nil {
+		return false
+	}
+	return p.contains(typ)
+}
+
+// Len returns the number of types the Provider has rules for.
+// Types that are automatically provided are not counted.
+func (p *Provider) Len() int {
+	n := 0
+	for _, r := range p.rules {
+		n += len(r.outputs())
+	}
+	return n
+}
+
+// ValueCount returns the number of values the Provider currently holds,
+// whether they were constructed or added directly.
+func (p *Provider) ValueCount() int {
+	return len(p.values)
+}
+
+// ProvideInto calls fn with all of its arguments provided,
+// returning the first non-nil error fn returns, if any:
+//
+//     err := p.ProvideInto(func(db *DB, logger Logger) error {
+//         return migrate(db, logger)
 //     })
 //
-// The error return is optional, but any interface value that implements
-// error will be considered to be an error.
+// fn may also be a pointer to a function, such as a function stored in a struct field,
+// in which case the function it points to is called.
 //
-func (p *Provider) AddRule(provideFn interface{}) error {
+func (p *Provider) ProvideInto(fn interface{}) error {
 	p.init()
 
-	initializers, err := customProvide(provideFn)
+	v, err := funcOf("ProvideInto", fn)
 	if err != nil {
 		return err
 	}
 
-	for _, init := range initializers {
-		tasks := [...]task{
-			{init.Type, false},
-			{init.Type, true},
-		}
-		for _, t := range tasks {
-			if _, ok := p.tasks[t]; ok {
-				return errors.New("trying to provide the same type " + t.Type.String() + " in multiple ways")
-			}
+	t := v.Type()
+	inputs := make([]reflect.Value, t.NumIn())
+	unlock := p.lock()
+	defer p.doneCancelling()
+	for i := range inputs {
+		value, err := p.get(t.In(i))
+		if err != nil {
+			unlock()
+			return err
 		}
-		p.tasks[tasks[0]] = init.Partial
-		p.tasks[tasks[1]] = init.Complete
+		inputs[i] = value
 	}
-	return nil
+	unlock()
+	return call(v, inputs)
 }
 
-// Provide, given a set of non-nil pointers, will construct, initialize,
-// and set the values they point to using the rules the Provider has been given.
+// ProvideAndRun is the usual way to start an application: it provides
+// everything fn takes, in one call to Provide, and then calls fn,
+// returning any error from either:
 //
-// Note that if the value you are trying to get is itself a pointer (and it often is,)
-// you will have to give Provide a pointer-to-pointer:
+//     err := p.ProvideAndRun(func(srv *Server, db *DB) error {
+//         return srv.Serve()
+//     })
 //
-//     var aPtr *A
-//     var bInterface B
-//     err := p.Provide(&aPtr, &b)
-//     // aPtr and bInterface are now non-nil
+// Unlike ProvideInto, everything fn takes is provided before fn is called,
+// so eager rules (see AddEager) and transactions (see WithTransactional) apply,
+// and a failure is remembered by LastError.
 //
-func (p *Provider) Provide(ptrsToRequests ...interface{}) error {
+func (p *Provider) ProvideAndRun(fn interface{}) error {
 	p.init()
 
-	for _, ptr := range ptrsToRequests {
-		vptr := reflect.ValueOf(ptr)
-		if vptr.Kind() != reflect.Ptr || vptr.IsNil() {
-			return errors.New("arguments to Provide must be non-nil pointers (that Provide will set)")
-		}
+	v, err := funcOf("ProvideAndRun", fn)
+	if err != nil {
+		return err
+	}
 
-		v := vptr.Elem()
-		t := v.Type()
-		if isErrorType(t) {
-			return errors.New("since " + t.String() + " implements error, it is considered an error and cannot be provided")
-		}
+	t := v.Type()
+	ptrs := make([]interface{}, t.NumIn())
+	for i := range ptrs {
+		ptrs[i] = reflect.New(t.In(i)).Interface()
+	}
+	if err := p.Provide(ptrs...); err != nil {
+		return err
+	}
 
-		if err := p.complete(t); err != nil {
-			return err
-		}
+	inputs := make([]reflect.Value, len(ptrs))
+	for i, ptr := range ptrs {
+		inputs[i] = reflect.ValueOf(ptr).Elem()
+	}
+	return call(v, inputs)
+}
 
-		value, ok := p.values[t]
-		if !ok {
-			return errors.New("should never happen: couldn't find value")
-		}
+// funcOf finds the function to call given a function or a pointer to one (see ProvideInto).
+func funcOf(method string, fn interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return v, errors.New(method + " must be given a non-nil function or pointer to a function")
+	}
+	return v, nil
+}
 
-		v.Set(value)
+// call calls a function, returning the first non-nil error it returns.
+func call(v reflect.Value, inputs []reflect.Value) error {
+	t := v.Type()
+	var outputs []reflect.Value
+	if t.IsVariadic() {
+		outputs = v.CallSlice(inputs)
+	} else {
+		outputs = v.Call(inputs)
+	}
+	for i, out := range outputs {
+		if IsErrorType(t.Out(i)) && !out.IsNil() {
+			return out.Interface().(error)
+		}
 	}
 	return nil
 }
@@ -136,9 +1525,117 @@ func (p *Provider) init() {
 	if p.values == nil {
 		p.values = make(map[reflect.Type]reflect.Value)
 	}
+	if p.factories == nil {
+		p.factories = make(map[reflect.Type]interface{})
+	}
+	if p.hooks == nil {
+		p.hooks = make(map[reflect.Type][]reflect.Value)
+	}
+	if p.annotations == nil {
+		p.annotations = make(map[reflect.Type]map[string]string)
+	}
+	if p.prototypes == nil {
+		p.prototypes = make(map[reflect.Type]reflect.Type)
+	}
+	if p.ruleOf == nil {
+		p.ruleOf = make(map[reflect.Type]rule)
+	}
+	if p.failed == nil {
+		p.failed = make(map[reflect.Type]bool)
+	}
+	if p.prerequisites == nil {
+		p.prerequisites = make(map[reflect.Type][]reflect.Type)
+	}
+}
+
+func (p *Provider) hasRule(typ reflect.Type) bool {
+	_, ok := p.ruleFor(typ)
+	return ok
+}
+
+// ruleFor finds the rule added to this Provider that outputs a type, if there is one.
+func (p *Provider) ruleFor(typ reflect.Type) (rule, bool) {
+	r, ok := p.ruleOf[typ]
+	return r, ok
+}
+
+// indexRules indexes the Provider's rules again (see ruleFor), after they've been replaced or removed.
+func (p *Provider) indexRules() {
+	p.ruleOf = make(map[reflect.Type]rule, len(p.rules))
+	p.eager = nil
+	for _, r := range p.rules {
+		p.index(r)
+	}
+}
+
+// index adds a rule to the index of rules by their outputs.
+func (p *Provider) index(r rule) {
+	outs := r.outputs()
+	for _, out := range outs {
+		p.ruleOf[out] = r
+	}
+	if r.IsEager {
+		p.eager = append(p.eager, outs...)
+	}
+}
+
+func (p *Provider) contains(typ reflect.Type) bool {
+	_, ok := p.lookup(typ)
+	return ok
+}
+
+// lookup returns the fully constructed value for a type, if there already is one.
+func (p *Provider) lookup(typ reflect.Type) (reflect.Value, bool) {
+	if s, ok := p.tasks[task{typ, true}]; ok {
+		value, hasValue := p.values[typ]
+		return value, s.Done && hasValue
+	}
+	if p.parent != nil {
+		if _, isFactory := p.parent.factory(typ); !isFactory {
+			return p.parent.lookup(typ)
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func (p *Provider) get(t reflect.Type) (reflect.Value, error) {
+	if IsErrorType(t) {
+		return reflect.Value{}, errors.New("since " + t.String() + " implements error, it is considered an error and cannot be provided")
+	}
+	if t.Implements(errorType) {
+		return reflect.Value{}, errors.New("providing a type that implements error is not supported — use a wrapper type instead of " + t.String())
+	}
+	if p.frozen {
+		if value, ok := p.lookup(t); ok {
+			return value, nil
+		}
+		return reflect.Value{}, errors.New("can't construct " + t.String() + " after Freeze")
+	}
+
+	if err := p.complete(t); err != nil {
+		for _, fn := range p.onError {
+			fn(t, err)
+		}
+		return reflect.Value{}, err
+	}
+
+	value, ok := p.values[t]
+	if !ok {
+		return reflect.Value{}, errors.New("should never happen: couldn't find value")
+	}
+	if fn, ok := p.values[p.prototypes[t]]; ok {
+		// Every request for a prototype gets a new value.
+		value = fn.Call(nil)[0]
+	}
+	p.setDefaultLogger(t, value)
+	return value, nil
 }
 
 func (p *Provider) complete(typ reflect.Type) error {
+	if p.parallel {
+		return p.completeParallel(typ)
+	}
+
 	goals := []task{{typ, true}}
 	for i := 0; i < len(goals); i++ {
 		newlyDone, err := p.do(goals[i])
@@ -155,9 +1652,16 @@ func (p *Provider) complete(typ reflect.Type) error {
 	return nil
 }
 
-func (p *Provider) do(t task) ([]task, error) {
+func (p *Provider) do(t task) (_ []task, err error) {
 	stack := []task{t}
 	newlyDone := make([]task, 0, 2)
+	var spans []task
+	defer func() {
+		// The spans of tasks that were never done end with the error that stopped them.
+		for _, t := range spans {
+			p.endSpan(t, err)
+		}
+	}()
 	for len(stack) > 0 {
 		t = stack[len(stack)-1]
 		s, err := p.state(t)
@@ -166,9 +1670,15 @@ func (p *Provider) do(t task) ([]task, error) {
 		}
 
 		if !s.Done && !s.InProgress {
+			if s.Do != nil && p.startSpan(stack) {
+				spans = append(spans, t)
+			}
+
 			// The task's dependencies need to be scheduled.
+			// They're pushed in reverse, so that they're done in the order they're listed.
 			hasDeps := false
-			for _, dep := range s.DependsOn {
+			for d := len(s.DependsOn) - 1; d >= 0; d-- {
+				dep := s.DependsOn[d]
 				depState, err := p.state(dep)
 				if err != nil {
 					return nil, err
@@ -178,19 +1688,21 @@ func (p *Provider) do(t task) ([]task, error) {
 					continue
 				}
 				if depState.InProgress {
-					i := 0
-					for ; i < len(stack); i++ {
-						if stack[i] == t {
+					// Every task that's in progress is waiting on the tasks above it in the stack,
+					// so the cycle is the tasks in progress from dep up to t.
+					var cycle []reflect.Type
+					for i := len(stack) - 1; i >= 0; i-- {
+						if stack[i] == t || (len(cycle) > 0 && p.tasks[stack[i]].InProgress) {
+							cycle = append(cycle, stack[i].Type)
+						}
+						if len(cycle) > 0 && stack[i] == dep {
 							break
 						}
 					}
-
-					cycle := make([]string, 0, len(stack)-i)
-					for ; i < len(stack); i++ {
-						cycle = append(cycle, stack[i].Type.String())
+					for i, j := 0, len(cycle)-1; i < j; i, j = i+1, j-1 {
+						cycle[i], cycle[j] = cycle[j], cycle[i]
 					}
-
-					return nil, errors.New("cycle: " + strings.Join(cycle, " --> "))
+					return nil, p.cycleError(append(cycle, dep.Type))
 				}
 				stack = append(stack, dep)
 				hasDeps = true
@@ -206,15 +1718,29 @@ func (p *Provider) do(t task) ([]task, error) {
 		if !s.Done && s.InProgress {
 			// We're returning after dependencies have been completed.
 			if s.Do != nil {
-				if err = s.Do(p.values); err != nil {
+				if p.cancelled.CompareAndSwap(true, false) {
+					return nil, errors.New("cancelled by CancelInitialization")
+				}
+				err = p.run(t, s, p.values)
+				p.endSpan(t, err)
+				if err != nil {
+					p.failed[t.Type] = true
 					return nil, err
 				}
+				p.history = append(p.history, step{t, s})
 				newlyDone = append(newlyDone, t)
 			}
+			// DependsOn is kept around to describe the dependency graph.
 			s.Done = true
-			s.DependsOn = nil
 			s.Do = nil
 			p.tasks[t] = s
+			p.doneWith(t, s)
+
+			if t.Complete {
+				if err = p.postConstruct(t.Type); err != nil {
+					return nil, err
+				}
+			}
 		}
 
 		// This task has been completed.
@@ -224,17 +1750,300 @@ func (p *Provider) do(t task) ([]task, error) {
 	return newlyDone, nil
 }
 
+func (p *Provider) postConstruct(typ reflect.Type) error {
+	if p.transformer != nil {
+		v := p.transformer(typ, p.values[typ])
+		if !v.IsValid() || !v.Type().AssignableTo(typ) {
+			return errors.New("value transformer returned a value that can't be used as " + typ.String())
+		}
+		converted := reflect.New(typ).Elem()
+		converted.Set(v)
+		p.values[typ] = converted
+	}
+	p.setDefaultLogger(typ, p.values[typ])
+
+	for _, hook := range p.hooks[typ] {
+		outputs := hook.Call([]reflect.Value{p.values[typ]})
+		if len(outputs) > 0 && !outputs[0].IsNil() {
+			return outputs[0].Interface().(error)
+		}
+	}
+	return nil
+}
+
+// cycleError describes a cycle of types, each depending on the next,
+// suggesting a provide:"circular" field that could break it if there is one.
+func (p *Provider) cycleError(cycle []reflect.Type) error {
+	var names []string
+	for i, typ := range cycle {
+		if i == 0 || typ != cycle[i-1] {
+			names = append(names, typ.String())
+		}
+	}
+	message := "cycle: " + strings.Join(names, " --> ")
+
+	for i := 0; i+1 < len(cycle); i++ {
+		from, to := cycle[i], cycle[i+1]
+		if from.Kind() != reflect.Ptr || from.Elem().Kind() != reflect.Struct || !IsReferenceType(to) || p.hasRule(from) {
+			continue
+		}
+
+		elem := from.Elem()
+		for j := 0; j < elem.NumField(); j++ {
+			field := elem.Field(j)
+			tag, ok := field.Tag.Lookup("provide")
+			if ok && field.Type == to && (tag == "" || tag == p.defaultTag) {
+				return errors.New(
+					message + ". Possible fix: add `provide:\"circular\"` to the " + elem.Name() + "." + field.Name +
+						" field (it is a " + referenceKind(to) + " type, so it can be a circular dependency)",
+				)
+			}
+		}
+	}
+	return errors.New(message)
+}
+
+// warn reports something suspicious about how the Provider has been set up,
+// which is an error in strict mode.
+func (p *Provider) warn(warning string) error {
+	if p.strict {
+		return errors.New(warning)
+	}
+	p.notify(warning)
+	return nil
+}
+
+func (p *Provider) notify(warning string) {
+	for _, fn := range p.onWarning {
+		fn(warning)
+	}
+}
+
+func (p *Provider) run(t task, s state, values map[reflect.Type]reflect.Value) error {
+	p.refreshPrototypes(t, s, values)
+	if len(p.middlewares) == 0 {
+		return p.traced(t, s, values)()
+	}
+
+	called := false
+	traced := p.traced(t, s, values)
+	next := func() error {
+		called = true
+		return traced()
+	}
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		middleware, inner := p.middlewares[i], next
+		next = func() error {
+			return middleware(t.Type, inner)
+		}
+	}
+	if err := next(); err != nil || called || t.Complete {
+		return err
+	}
+
+	// Middleware skipped constructing the value.
+	outputs := s.Outputs
+	if len(outputs) == 0 {
+		outputs = []reflect.Type{t.Type}
+	}
+	for _, out := range outputs {
+		if _, ok := values[out]; !ok {
+			values[out] = reflect.Zero(out)
+		}
+	}
+	return nil
+}
+
+// refreshPrototypes calls the prototype functions (see AddPrototype) for a task's dependencies,
+// so that it gets a new value of each of those types.
+func (p *Provider) refreshPrototypes(t task, s state, values map[reflect.Type]reflect.Value) {
+	if len(p.prototypes) == 0 || s.Do == nil {
+		return
+	}
+
+	for _, dep := range s.DependsOn {
+		if fn, ok := values[p.prototypes[dep.Type]]; ok && dep.Type != t.Type {
+			values[dep.Type] = fn.Call(nil)[0]
+		}
+	}
+}
+
+// doneWith marks the tasks for the other outputs of a rule as done, once a task for one of them
+// has called it, so the rule is only called once. Outputs whose values weren't set along the way
+// (like the types provided by prototype functions) are left to their own tasks.
+func (p *Provider) doneWith(t task, s state) {
+	for _, out := range s.Outputs {
+		other := task{out, false}
+		if other == t {
+			continue
+		}
+		if _, ok := p.values[out]; !ok {
+			continue
+		}
+		if done, ok := p.tasks[other]; ok && !done.Done {
+			done.Done = true
+			done.Do = nil
+			p.tasks[other] = done
+		}
+	}
+}
+
 func (p *Provider) state(t task) (state, error) {
 	if s, ok := p.tasks[t]; ok {
 		return s, nil
 	}
 
-	init, err := autoProvide(t.Type)
+	initializers, err := p.initializers(t.Type)
 	if err != nil {
 		return state{}, err
 	}
 
-	p.tasks[task{t.Type, false}] = init.Partial
-	p.tasks[task{t.Type, true}] = init.Complete
+	for _, init := range initializers {
+		if _, ok := p.tasks[task{init.Type, false}]; !ok {
+			p.set(init)
+		}
+	}
 	return p.tasks[t], nil
 }
+
+// peek is like state, but doesn't remember the state of a type it hasn't seen before.
+func (p *Provider) peek(t task) (state, error) {
+	if s, ok := p.tasks[t]; ok {
+		return s, nil
+	}
+
+	initializers, err := p.initializers(t.Type)
+	if err != nil {
+		return state{}, err
+	}
+
+	if s, ok := p.tasks[t]; ok {
+		// The function set by SetOnMissingRule added a rule for it.
+		return s, nil
+	}
+	for _, init := range initializers {
+		if init.Type == t.Type {
+			if t.Complete {
+				return init.Complete, nil
+			}
+			return init.Partial, nil
+		}
+	}
+	return state{}, errors.New("should never happen: no initializer for " + t.Type.String())
+}
+
+// initializers creates what's needed to provide a type without a rule,
+// which may include other types when it's provided by a factory.
+func (p *Provider) initializers(typ reflect.Type) ([]initializer, error) {
+	if v, ok := p.sharedValue(typ); ok {
+		return []initializer{share(typ, v)}, nil
+	}
+
+	// Types in the Provider's own scope are constructed here, not by its parent.
+	scope := typeScope(typ)
+	if p.parent != nil && (scope == "" || scope != p.scope) {
+		if factoryFn, ok := p.parent.factory(typ); ok {
+			return customProvide(factoryFn)
+		}
+		return []initializer{inherit(p.parent, typ)}, nil
+	}
+	if scope != "" && scope != p.scope {
+		return nil, errors.New(typ.String() + " is only provided in scope " + scope + " (see OfScope)")
+	}
+
+	init, err := autoProvide(typ, p.defaultTag)
+	if ptrTo := reflect.PtrTo(typ); typ.Kind() == reflect.Struct && (p.hasRule(ptrTo) || containsType(p.seeded, ptrTo)) {
+		// A struct with provide-tagged fields would be constructed on its own,
+		// but if there's a rule or value for a pointer to it, that's what should be used.
+		// A *T that was only constructed automatically doesn't count,
+		// so that T is the same whichever of them is provided first.
+		init, err = autoDeref(typ), nil
+	}
+	if p.onMissingRule != nil && (err != nil || !p.canDeref(typ)) {
+		if err := p.onMissingRule(typ); err != nil {
+			return nil, err
+		}
+		if _, ok := p.tasks[task{typ, false}]; ok {
+			// A rule was added for it.
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []initializer{init}, nil
+}
+
+// canDeref reports whether an automatically provided value type
+// could be dereferenced from a pointer, if that's how it would be provided.
+func (p *Provider) canDeref(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Interface {
+		return true
+	}
+	if !providedThroughPtr(typ) {
+		return true
+	}
+
+	ptrTo := reflect.PtrTo(typ)
+	if _, ok := p.tasks[task{ptrTo, false}]; ok {
+		return true
+	}
+	_, err := autoProvide(ptrTo, p.defaultTag)
+	return err == nil
+}
+
+func (p *Provider) set(init initializer) {
+	if prerequisites := p.prerequisites[init.Type]; len(prerequisites) > 0 {
+		// DependsOn may be shared with other outputs of the same rule.
+		deps := make([]task, 0, len(init.Partial.DependsOn)+len(prerequisites))
+		deps = append(deps, init.Partial.DependsOn...)
+		for _, prerequisite := range prerequisites {
+			deps = append(deps, task{prerequisite, true})
+		}
+		init.Partial.DependsOn = deps
+	}
+	p.tasks[task{init.Type, false}] = init.Partial
+	p.tasks[task{init.Type, true}] = init.Complete
+}
+
+func (p *Provider) addValue(typ reflect.Type, v reflect.Value) error {
+	if p.frozen {
+		return errors.New("can't add values to a Provider after Freeze")
+	}
+	if p.sealed {
+		return errors.New("can't add values to a Provider after SealRules")
+	}
+	if IsErrorType(typ) {
+		return errors.New("since " + typ.String() + " implements error, it is considered an error and cannot be provided")
+	}
+	if r, ok := p.ruleFor(typ); ok && r.Priority == defaultPriority {
+		// Values replace defaults (see AddDefault), as long as they haven't been used.
+		if s := p.tasks[task{typ, false}]; !s.Done && !s.InProgress {
+			p.override(typ)
+		}
+	}
+	if _, ok := p.tasks[task{typ, false}]; ok {
+		return errors.New("trying to provide the same type " + typ.String() + " in multiple ways")
+	}
+
+	converted := reflect.New(typ).Elem()
+	converted.Set(v)
+	p.seed(typ, converted)
+	return nil
+}
+
+func (p *Provider) seed(typ reflect.Type, v reflect.Value) {
+	p.tasks[task{typ, false}] = state{Done: true}
+	p.tasks[task{typ, true}] = state{Done: true}
+	p.values[typ] = v
+	p.seeded = append(p.seeded, typ)
+}
+
+func (p *Provider) factory(typ reflect.Type) (interface{}, bool) {
+	for ; p != nil; p = p.parent {
+		if factoryFn, ok := p.factories[typ]; ok {
+			return factoryFn, true
+		}
+	}
+	return nil, false
+}
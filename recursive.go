@@ -0,0 +1,151 @@
+package provide
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+)
+
+var recursiveProviderType = reflect.TypeOf((*RecursiveProvider)(nil))
+
+// DefaultMaxRecursionDepth is how deeply a recursive rule (see AddRecursive)
+// may call itself unless the Provider has been given a different limit.
+const DefaultMaxRecursionDepth = 100
+
+// A RecursiveProvider is given to a recursive rule (see AddRecursive)
+// so that it can construct more values of the types it outputs.
+type RecursiveProvider struct {
+	p     *Provider
+	fn    reflect.Value
+	depth int
+}
+
+// Depth returns how many recursive calls deep the current call of the rule is.
+// The call that constructs the value the Provider keeps has depth 0.
+func (r *RecursiveProvider) Depth() int {
+	return r.depth
+}
+
+// Provide works like Provider.Provide, except that values of the
+// types the recursive rule outputs are constructed afresh by calling the rule again,
+// rather than shared. Those values aren't kept by the Provider.
+func (r *RecursiveProvider) Provide(ptrsToRequests ...interface{}) error {
+	t := r.fn.Type()
+	for _, ptr := range ptrsToRequests {
+		vptr := reflect.ValueOf(ptr)
+		if vptr.Kind() != reflect.Ptr || vptr.IsNil() {
+			return errors.New("arguments to Provide must be non-nil pointers (that Provide will set)")
+		}
+
+		v := vptr.Elem()
+		out := -1
+		for i := 0; i < t.NumOut(); i++ {
+			if t.Out(i) == v.Type() {
+				out = i
+				break
+			}
+		}
+		if out < 0 {
+			// This is already inside a call to Provide, which may hold the Provider's lock
+			// (see SetConcurrentSafe), so it mustn't go through Provide again.
+			value, err := r.p.get(v.Type())
+			if err != nil {
+				return err
+			}
+			v.Set(value)
+			continue
+		}
+
+		if r.depth >= r.p.maxRecursionDepth() {
+			return errors.New("exceeded the maximum recursion depth of " + strconv.Itoa(r.p.maxRecursionDepth()) + " constructing " + v.Type().String())
+		}
+
+		deeper := &RecursiveProvider{r.p, r.fn, r.depth + 1}
+		inputs := make([]reflect.Value, t.NumIn())
+		inputs[0] = reflect.ValueOf(deeper)
+		for i := 1; i < len(inputs); i++ {
+			value, err := r.p.get(t.In(i))
+			if err != nil {
+				return err
+			}
+			inputs[i] = value
+		}
+
+		outputs := r.fn.Call(inputs)
+		for i := range outputs {
+			if IsErrorType(t.Out(i)) && !outputs[i].IsNil() {
+				return outputs[i].Interface().(error)
+			}
+		}
+		v.Set(outputs[out])
+	}
+	return nil
+}
+
+// AddRecursive adds a rule that can call itself to construct values of the
+// types it outputs, for recursive data structures like trees. The rule's first argument
+// must be a *RecursiveProvider, which it can use to provide fresh values of its own outputs:
+//
+//     p.AddRecursive(func(r *provide.RecursiveProvider, config *TreeConfig) (*Node, error) {
+//         node := &Node{}
+//         if r.Depth() < config.Depth {
+//             node.Children = make([]*Node, config.Fanout)
+//             for i := range node.Children {
+//                 if err := r.Provide(&node.Children[i]); err != nil {
+//                     return nil, err
+//                 }
+//             }
+//         }
+//         return node, nil
+//     })
+//
+// Otherwise, the rule works like any other: the value it constructs at depth 0
+// is the one the Provider keeps. Recursion deeper than the limit set with
+// SetMaxRecursionDepth (or DefaultMaxRecursionDepth) is an error.
+//
+func (p *Provider) AddRecursive(recursiveFn interface{}) error {
+	p.init()
+	return p.addRule(rule{Fn: recursiveFn, IsRecursive: true})
+}
+
+// SetMaxRecursionDepth limits how deeply recursive rules (see AddRecursive) may call themselves.
+// A limit of 0 or less means DefaultMaxRecursionDepth.
+func (p *Provider) SetMaxRecursionDepth(n int) {
+	p.maxDepth = n
+}
+
+func (p *Provider) maxRecursionDepth() int {
+	if p.maxDepth <= 0 {
+		return DefaultMaxRecursionDepth
+	}
+	return p.maxDepth
+}
+
+// recursive wraps a recursive rule into a plain rule that
+// gives it a RecursiveProvider at depth 0.
+func (p *Provider) recursive(recursiveFn interface{}) (interface{}, error) {
+	v := reflect.ValueOf(recursiveFn)
+	if v.Kind() != reflect.Func || v.IsNil() || v.Type().NumIn() == 0 || v.Type().In(0) != recursiveProviderType {
+		return nil, errors.New("recursive rules must be functions that take a *RecursiveProvider as their first argument")
+	}
+	t := v.Type()
+
+	ins := make([]reflect.Type, t.NumIn()-1)
+	for i := range ins {
+		ins[i] = t.In(i + 1)
+	}
+	outs := make([]reflect.Type, t.NumOut())
+	for i := range outs {
+		outs[i] = t.Out(i)
+	}
+
+	wrapped := reflect.MakeFunc(reflect.FuncOf(ins, outs, t.IsVariadic()), func(args []reflect.Value) []reflect.Value {
+		r := &RecursiveProvider{p: p, fn: v}
+		args = append([]reflect.Value{reflect.ValueOf(r)}, args...)
+		if t.IsVariadic() {
+			return v.CallSlice(args)
+		}
+		return v.Call(args)
+	})
+	return wrapped.Interface(), nil
+}
@@ -0,0 +1,73 @@
+package provide_test
+
+import (
+	"github.com/MatthewValentine/provide"
+	"testing"
+)
+
+func TestRecursive(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	err = p.AddRecursive(func(r *provide.RecursiveProvider, kp KrabbyPatty) (*Coral, error) {
+		coral := &Coral{Patty: kp, Depth: r.Depth()}
+		if r.Depth() < 2 {
+			coral.Branches = make([]*Coral, 2)
+			for i := range coral.Branches {
+				if err := r.Provide(&coral.Branches[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return coral, nil
+	})
+	assert(t, err == nil, err)
+
+	var coral *Coral
+	err = p.Provide(&coral)
+	assert(t, err == nil, err)
+	assert(t, coral.Depth == 0 && len(coral.Branches) == 2, coral)
+	assert(t, coral.Branches[0] != coral.Branches[1], coral)
+	leaf := coral.Branches[1].Branches[0]
+	assert(t, leaf.Depth == 2 && len(leaf.Branches) == 0 && leaf.Patty == "jabberwocky", leaf)
+
+	p = &provide.Provider{}
+	p.SetMaxRecursionDepth(3)
+	err = p.AddRecursive(func(r *provide.RecursiveProvider) (*Coral, error) {
+		coral := &Coral{Branches: make([]*Coral, 1)}
+		return coral, r.Provide(&coral.Branches[0])
+	})
+	assert(t, err == nil, err)
+	err = p.Provide(&coral)
+	assert(t, err != nil, "recursion should be limited")
+
+	err = p.AddRecursive(func(kp KrabbyPatty) *Order { return nil })
+	assert(t, err != nil, "recursive rules need a *RecursiveProvider")
+}
+
+func TestRecursiveConcurrentSafe(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+	p.SetConcurrentSafe(true)
+
+	err = p.AddRecursive(func(r *provide.RecursiveProvider) (*Coral, error) {
+		// KrabbyPatty isn't an output of this rule, so it comes from the Provider, which is locked.
+		coral := &Coral{Depth: r.Depth()}
+		return coral, r.Provide(&coral.Patty)
+	})
+	assert(t, err == nil, err)
+
+	var coral *Coral
+	err = p.Provide(&coral)
+	assert(t, err == nil && coral.Patty == "jabberwocky", coral, err)
+}
+
+type Coral struct {
+	Patty    KrabbyPatty
+	Depth    int
+	Branches []*Coral
+}
@@ -0,0 +1,52 @@
+package provide
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// A RuleRegistry names the rules that configuration can choose from (see UnmarshalRules).
+type RuleRegistry map[string]interface{}
+
+// UnmarshalRules adds the rules from a registry that are enabled by a JSON object
+// mapping rule names to whether they're enabled, such as a configuration file:
+//
+//     registry := provide.RuleRegistry{
+//         "postgres": NewPostgresDB,
+//         "sqlite":   NewSQLiteDB,
+//     }
+//     err := p.UnmarshalRules([]byte(`{"postgres": true, "sqlite": false}`), registry)
+//
+// Rules that aren't mentioned, or are disabled, aren't added. The enabled rules are added
+// in order of their names. Naming a rule that isn't in the registry is an error,
+// and none of the rules are added if the configuration is invalid, or if any of them can't be added.
+//
+func (p *Provider) UnmarshalRules(data []byte, registry RuleRegistry) error {
+	p.init()
+
+	var enabled map[string]bool
+	if err := json.Unmarshal(data, &enabled); err != nil {
+		return errors.New("can't read rule configuration: " + err.Error())
+	}
+
+	var names []string
+	for name, on := range enabled {
+		if _, ok := registry[name]; !ok {
+			return errors.New("rule configuration names " + name + ", which isn't in the registry")
+		}
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	rules := make([]rule, len(names))
+	for i, name := range names {
+		rules[i] = rule{Fn: registry[name]}
+	}
+	if i, err := p.addRules(rules); err != nil {
+		return errors.New("can't add rule " + names[i] + ": " + err.Error())
+	}
+	return nil
+}
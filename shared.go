@@ -0,0 +1,65 @@
+package provide
+
+import (
+	"errors"
+	"reflect"
+)
+
+// AddShared makes the values that have already been constructed in shared available to p,
+// without giving p any of shared's rules. This lets independent Providers share
+// a pool of singletons, such as database connections, without either one owning the other:
+//
+//     pool := &Provider{}
+//     err := pool.Provide(&db)
+//
+//     err = api.AddShared(pool)
+//     err = worker.AddShared(pool)
+//     // api and worker both use the same db
+//
+// When p needs a type it has no rule for, it uses a value from shared if there is one
+// (checking shared Providers in the order they were added),
+// and only then falls back to its parent (see NewChild) or to automatically providing the type.
+// p never constructs anything in shared.
+//
+func (p *Provider) AddShared(shared *Provider) error {
+	p.init()
+
+	if shared == nil || shared == p {
+		return errors.New("AddShared needs a different, non-nil Provider")
+	}
+	if p.frozen {
+		return errors.New("can't add shared values to a Provider after Freeze")
+	}
+	if p.sealed {
+		return errors.New("can't add shared values to a Provider after SealRules")
+	}
+
+	p.shared = append(p.shared, shared)
+	return nil
+}
+
+// sharedValue finds an already constructed value for a type in a shared Provider.
+func (p *Provider) sharedValue(typ reflect.Type) (reflect.Value, bool) {
+	for _, shared := range p.shared {
+		if v, ok := shared.lookup(typ); ok {
+			return v, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// share creates an initializer that uses a value from a shared Provider.
+func share(typ reflect.Type, v reflect.Value) initializer {
+	return initializer{
+		Type: typ,
+		Partial: state{
+			Do: func(values map[reflect.Type]reflect.Value) error {
+				values[typ] = v
+				return nil
+			},
+		},
+		Complete: state{
+			DependsOn: []task{{typ, false}},
+		},
+	}
+}
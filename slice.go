@@ -0,0 +1,139 @@
+package provide
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+)
+
+// BindSlice adds a rule for the slice type sliceTypePtr points to,
+// which constructs the slice by calling each of elementRules and collecting their results in order:
+//
+//     err := p.BindSlice((*[]Check)(nil),
+//         func(db *DB) Check { return db.Ping },
+//         func(c *Cache) (Check, error) { return c.Check, c.Err() },
+//     )
+//
+// Each element rule is a function like any other rule, taking whatever it needs,
+// except that it must construct exactly one value, assignable to the slice's element type.
+// The element rules aren't added to the Provider separately.
+//
+func (p *Provider) BindSlice(sliceTypePtr interface{}, elementRules ...interface{}) error {
+	p.init()
+
+	typ, err := exampleType(sliceTypePtr)
+	if err != nil {
+		return err
+	}
+	if typ.Kind() != reflect.Slice {
+		return errors.New("BindSlice needs a slice type, not " + typ.String())
+	}
+
+	ruleFn, err := collectRule(typ, typ.Elem(), elementRules, func(elems []reflect.Value) (reflect.Value, error) {
+		slice := reflect.MakeSlice(typ, 0, len(elems))
+		return reflect.Append(slice, elems...), nil
+	})
+	if err != nil {
+		return err
+	}
+	return p.addRule(rule{Fn: ruleFn, IsGenerated: true})
+}
+
+// AddMapRule adds a rule for a map type, which constructs the map by calling each of valueRules
+// and keying their results with keyFn. keyFn must be a func(V) K for the map type map[K]V:
+//
+//     err := p.AddMapRule(func(h Handler) string { return h.Route() },
+//         NewUserHandler,
+//         NewOrderHandler,
+//     )
+//     // adds a rule for map[string]Handler
+//
+// Each value rule is like an element rule for BindSlice.
+// Constructing the map fails if two values have the same key.
+//
+func (p *Provider) AddMapRule(keyFn interface{}, valueRules ...interface{}) error {
+	p.init()
+
+	key := reflect.ValueOf(keyFn)
+	if key.Kind() != reflect.Func || key.IsNil() || key.Type().NumIn() != 1 || key.Type().NumOut() != 1 {
+		return errors.New("AddMapRule needs a key function that takes a value and returns its key")
+	}
+	elem, keyType := key.Type().In(0), key.Type().Out(0)
+	if !keyType.Comparable() {
+		return errors.New(keyType.String() + " can't be used as a map key")
+	}
+	typ := reflect.MapOf(keyType, elem)
+
+	ruleFn, err := collectRule(typ, elem, valueRules, func(elems []reflect.Value) (reflect.Value, error) {
+		m := reflect.MakeMapWithSize(typ, len(elems))
+		for _, elem := range elems {
+			k := key.Call([]reflect.Value{elem})[0]
+			if m.MapIndex(k).IsValid() {
+				return m, errors.New("more than one value for " + typ.String() + " has the same key")
+			}
+			m.SetMapIndex(k, elem)
+		}
+		return m, nil
+	})
+	if err != nil {
+		return err
+	}
+	return p.addRule(rule{Fn: ruleFn, IsGenerated: true})
+}
+
+// collectRule creates a rule for typ, which calls each of elementRules
+// to construct one elem each, and then assembles them with build.
+// The rule takes every type any of the element rules take, once each.
+func collectRule(typ, elem reflect.Type, elementRules []interface{}, build func([]reflect.Value) (reflect.Value, error)) (interface{}, error) {
+	funcs := make([]reflect.Value, len(elementRules))
+	for i, elementRule := range elementRules {
+		v := reflect.ValueOf(elementRule)
+		if v.Kind() != reflect.Func || v.IsNil() {
+			return nil, errors.New("element rule " + strconv.Itoa(i+1) + " for " + typ.String() + " must be a function")
+		}
+		outs := ruleOutputs(elementRule)
+		if len(outs) != 1 || !outs[0].AssignableTo(elem) {
+			return nil, errors.New("element rule " + strconv.Itoa(i+1) + " for " + typ.String() + " must construct exactly one " + elem.String())
+		}
+		funcs[i] = v
+	}
+
+	var ins []reflect.Type
+	index := make(map[reflect.Type]int)
+	for _, fn := range funcs {
+		t := fn.Type()
+		for i := 0; i < t.NumIn(); i++ {
+			if _, ok := index[t.In(i)]; !ok {
+				index[t.In(i)] = len(ins)
+				ins = append(ins, t.In(i))
+			}
+		}
+	}
+
+	ruleType := reflect.FuncOf(ins, []reflect.Type{typ, errorType}, false)
+	ruleFn := reflect.MakeFunc(ruleType, func(args []reflect.Value) []reflect.Value {
+		elems := make([]reflect.Value, 0, len(funcs))
+		for _, fn := range funcs {
+			t := fn.Type()
+			inputs := make([]reflect.Value, t.NumIn())
+			for i := range inputs {
+				inputs[i] = args[index[t.In(i)]]
+			}
+
+			for i, out := range fn.Call(inputs) {
+				if !IsErrorType(t.Out(i)) {
+					elems = append(elems, out.Convert(elem))
+				} else if !out.IsNil() {
+					return []reflect.Value{reflect.Zero(typ), out.Convert(errorType)}
+				}
+			}
+		}
+
+		v, err := build(elems)
+		if err != nil {
+			return []reflect.Value{reflect.Zero(typ), reflect.ValueOf(&err).Elem()}
+		}
+		return []reflect.Value{v, reflect.Zero(errorType)}
+	})
+	return ruleFn.Interface(), nil
+}
@@ -0,0 +1,130 @@
+package provide
+
+import "reflect"
+
+// A Snapshot records the state of a Provider at some point (see Provider.Snapshot),
+// including the order its values were constructed in.
+type Snapshot struct {
+	tasks     map[task]state
+	values    map[reflect.Type]reflect.Value
+	factories map[reflect.Type]interface{}
+	history   []step
+	rules     []rule
+	seeded    []reflect.Type
+	initOrder []reflect.Type
+
+	scopedRules   []rule
+	prototypes    map[reflect.Type]reflect.Type
+	prerequisites map[reflect.Type][]reflect.Type
+	annotations   map[reflect.Type]map[string]string
+}
+
+// Snapshot records the Provider's current rules and values,
+// along with the steps it took to construct its values, so it can be restored later
+// with RestoreSnapshot. Nothing is copied deeply: the values themselves are shared.
+// The prerequisites (see AddPrerequisite) and annotations (see AnnotateType) the Provider has
+// are recorded too, but not its configuration, such as middleware or hooks.
+func (p *Provider) Snapshot() *Snapshot {
+	p.init()
+
+	s := &Snapshot{
+		tasks:     make(map[task]state, len(p.tasks)),
+		values:    make(map[reflect.Type]reflect.Value, len(p.values)),
+		factories: make(map[reflect.Type]interface{}, len(p.factories)),
+		history:   append(p.history[:0:0], p.history...),
+		rules:     append(p.rules[:0:0], p.rules...),
+		seeded:    append(p.seeded[:0:0], p.seeded...),
+
+		scopedRules:   append(p.scopedRules[:0:0], p.scopedRules...),
+		prototypes:    make(map[reflect.Type]reflect.Type, len(p.prototypes)),
+		prerequisites: make(map[reflect.Type][]reflect.Type, len(p.prerequisites)),
+		annotations:   make(map[reflect.Type]map[string]string, len(p.annotations)),
+	}
+	for t, state := range p.tasks {
+		s.tasks[t] = state
+	}
+	for typ, v := range p.values {
+		s.values[typ] = v
+	}
+	for typ, fn := range p.factories {
+		s.factories[typ] = fn
+	}
+	for typ, fnType := range p.prototypes {
+		s.prototypes[typ] = fnType
+	}
+	for typ, prerequisites := range p.prerequisites {
+		s.prerequisites[typ] = append(prerequisites[:0:0], prerequisites...)
+	}
+	for typ := range p.annotations {
+		s.annotations[typ] = p.annotationsFor(typ)
+	}
+
+	first := make(map[reflect.Type]bool)
+	for _, step := range p.history {
+		types := step.State.Outputs
+		if len(types) == 0 {
+			types = []reflect.Type{step.Task.Type}
+		}
+		for _, typ := range types {
+			if !first[typ] {
+				first[typ] = true
+				s.initOrder = append(s.initOrder, typ)
+			}
+		}
+	}
+	return s
+}
+
+// InitOrder returns the types that had been constructed when the Snapshot was taken,
+// in the order they were first constructed.
+func (s *Snapshot) InitOrder() []reflect.Type {
+	return append(s.initOrder[:0:0], s.initOrder...)
+}
+
+// RestoreSnapshot puts the Provider back the way it was when the Snapshot was taken,
+// and then constructs each value the Provider had constructed by then again, like Replay,
+// in the same order as they were originally constructed (see Snapshot.InitOrder).
+// Any side effects that depend on that order, such as registering metrics,
+// happen in the same sequence as before.
+//
+// Values that were added to the Provider directly are restored as they were.
+func (p *Provider) RestoreSnapshot(s *Snapshot) error {
+	p.init()
+
+	p.tasks = make(map[task]state, len(s.tasks))
+	for t, state := range s.tasks {
+		p.tasks[t] = state
+	}
+	p.values = make(map[reflect.Type]reflect.Value, len(s.values))
+	for typ, v := range s.values {
+		p.values[typ] = v
+	}
+	p.factories = make(map[reflect.Type]interface{}, len(s.factories))
+	for typ, fn := range s.factories {
+		p.factories[typ] = fn
+	}
+	p.history = append(s.history[:0:0], s.history...)
+	p.rules = append(s.rules[:0:0], s.rules...)
+	p.indexRules()
+	p.seeded = append(s.seeded[:0:0], s.seeded...)
+	p.scopedRules = append(s.scopedRules[:0:0], s.scopedRules...)
+
+	p.prototypes = make(map[reflect.Type]reflect.Type, len(s.prototypes))
+	for typ, fnType := range s.prototypes {
+		p.prototypes[typ] = fnType
+	}
+	p.prerequisites = make(map[reflect.Type][]reflect.Type, len(s.prerequisites))
+	for typ, prerequisites := range s.prerequisites {
+		p.prerequisites[typ] = append(prerequisites[:0:0], prerequisites...)
+	}
+	p.annotations = make(map[reflect.Type]map[string]string, len(s.annotations))
+	for typ, annotations := range s.annotations {
+		p.annotations[typ] = make(map[string]string, len(annotations))
+		for k, v := range annotations {
+			p.annotations[typ][k] = v
+		}
+	}
+
+	// The history only includes constructed values, so this is the same as Replay.
+	return p.Replay()
+}
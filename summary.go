@@ -0,0 +1,141 @@
+package provide
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// Summarize writes a description of what providing the types roots point to would do,
+// without constructing anything. There is one line for each type involved,
+// in the order they would be constructed (or first needed), saying how each one would be provided:
+//
+//     1. app.Config: already provided
+//     2. *app.DB: rule app.NewDB (db.go:14)
+//     3. *app.Server: automatically
+//     4. app.Server: automatically, from *app.Server
+//
+// It returns an error, before writing anything, if any of the types can't be provided.
+//
+func (p *Provider) Summarize(w io.Writer, roots ...interface{}) error {
+	p.init()
+
+	var types []reflect.Type
+	for _, root := range roots {
+		typ, err := exampleType(root)
+		if err != nil {
+			return err
+		}
+		if err := p.check(typ); err != nil {
+			return err
+		}
+		types = append(types, typ)
+	}
+
+	var order []reflect.Type
+	listed := make(map[reflect.Type]bool)
+	visited := make(map[task]bool)
+	var visit func(t task)
+	visit = func(t task) {
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+
+		// check succeeded, so peek can't fail.
+		s, _ := p.peek(t)
+		if !s.Done && s.From == nil {
+			for _, dep := range s.DependsOn {
+				visit(dep)
+			}
+		}
+		if !listed[t.Type] {
+			listed[t.Type] = true
+			order = append(order, t.Type)
+		}
+	}
+	for _, typ := range types {
+		visit(task{typ, true})
+	}
+
+	for i, typ := range order {
+		line := strconv.Itoa(i+1) + ". " + typ.String() + ": " + p.describe(typ) + "\n"
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InspectRule describes the rule that would be used to construct the type typeExample points to,
+// without constructing anything. For a rule added to the Provider (or a factory, see AddFactory),
+// inputTypes are its arguments and source says where it was defined, like "main.NewServer (server.go:12)".
+// For a type that would be provided automatically, isAuto is set, and inputTypes are the types of its
+// provide-tagged fields followed by the arguments to its PleaseProvide method.
+//
+// InspectRule returns an error if there's no rule for the type, such as when it was added as a value,
+// or when it can't be provided automatically.
+//
+func (p *Provider) InspectRule(typeExample interface{}) (inputTypes []reflect.Type, isAuto bool, source string, err error) {
+	p.init()
+
+	typ, err := exampleType(typeExample)
+	if err != nil {
+		return nil, false, "", err
+	}
+
+	fn, ok := p.factory(typ)
+	if r, isRule := p.ruleFor(typ); isRule {
+		fn, ok = r.Fn, true
+	}
+	if ok {
+		t := reflect.TypeOf(fn)
+		for i := 0; i < t.NumIn(); i++ {
+			if in := t.In(i); in != recursiveProviderType {
+				inputTypes = append(inputTypes, in)
+			}
+		}
+		return inputTypes, false, ruleSource(fn), nil
+	}
+
+	if containsType(p.seeded, typ) {
+		return nil, false, "", errors.New(typ.String() + " was added as a value, not with a rule")
+	}
+	init, err := autoProvide(typ, p.defaultTag)
+	if err != nil {
+		return nil, false, "", err
+	}
+	for _, deps := range [...][]task{init.Partial.DependsOn, init.Complete.DependsOn} {
+		for _, dep := range deps {
+			if dep.Type != typ {
+				inputTypes = append(inputTypes, dep.Type)
+			}
+		}
+	}
+	return inputTypes, true, "", nil
+}
+
+// describe says how a type would be provided.
+func (p *Provider) describe(typ reflect.Type) string {
+	if s, _ := p.peek(task{typ, true}); s.Done {
+		return "already provided"
+	}
+	if r, ok := p.ruleFor(typ); ok {
+		return "rule " + ruleSource(r.Fn)
+	}
+	if fn, ok := p.factory(typ); ok {
+		return "factory " + ruleSource(fn)
+	}
+	if _, ok := p.sharedValue(typ); ok {
+		return "shared from another Provider"
+	}
+	if s, _ := p.peek(task{typ, false}); s.From != nil {
+		return "from the parent Provider"
+	}
+
+	if typ.Kind() == reflect.Ptr || !providedThroughPtr(typ) {
+		return "automatically"
+	}
+	return "automatically, from " + reflect.PtrTo(typ).String()
+}
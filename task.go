@@ -12,6 +12,15 @@ type state struct {
 	InProgress bool
 	DependsOn  []task
 	Do         func(map[reflect.Type]reflect.Value) error
+
+	// From is the parent Provider the value is shared from, if any.
+	From *Provider
+
+	// Outputs are the types Do sets, when it sets more than just the task's own type.
+	Outputs []reflect.Type
+	// Exclusive is set when Do uses the Provider itself,
+	// so it can't run at the same time as anything else (see SetParallelism).
+	Exclusive bool
 }
 
 type initializer struct {
@@ -19,3 +28,34 @@ type initializer struct {
 	Partial  state
 	Complete state
 }
+
+// A step is a task that has been done, along with
+// the state that was used to do it.
+type step struct {
+	Task  task
+	State state
+}
+
+// A rule is a function that has been added to a Provider.
+type rule struct {
+	Fn          interface{}
+	IsFactory   bool
+	IsWeak      bool
+	IsRecursive bool
+	IsEager     bool
+	Scope       string
+	Priority    int
+
+	// Lazy is set for rules added with AddLazyRule.
+	Lazy *lazyCall
+
+	// Condition is set for rules added with AddConditionalModule.
+	Condition *moduleCondition
+
+	// IsPrototype is set for rules added with AddPrototype.
+	IsPrototype bool
+
+	// IsGenerated is set for rules the package makes itself, such as for AddValueFunc,
+	// which don't get the warnings meant for rules written by hand (see ruleWarnings).
+	IsGenerated bool
+}
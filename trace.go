@@ -0,0 +1,246 @@
+package provide
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// A TraceEntry records a single step a Provider took to construct a value.
+//
+// Phase is "construct" for the step that first creates the value,
+// such as calling a rule or allocating a struct to be automatically provided,
+// and "initialize" for the step that sets its provide-tagged fields and calls PleaseProvide.
+// Deps lists the types that had to be provided before the step could run.
+//
+type TraceEntry struct {
+	Type     reflect.Type
+	Phase    string
+	Deps     []reflect.Type
+	Duration time.Duration
+	Error    error
+}
+
+// StartTrace makes a Provider record every step it takes to construct values
+// until the returned function is called. The returned function stops tracing
+// and returns the steps in the order they ran:
+//
+//     stop := p.StartTrace()
+//     err := p.Provide(&server)
+//     for _, entry := range stop() {
+//         log.Println(entry.Type, entry.Phase, entry.Duration)
+//     }
+//
+func (p *Provider) StartTrace() func() []TraceEntry {
+	p.trace = []TraceEntry{}
+	return func() []TraceEntry {
+		entries := p.trace
+		p.trace = nil
+		return entries
+	}
+}
+
+// A Tracer starts a span for each step a Provider takes to construct a value (see WithTracer).
+// attributes describe the step; the returned context.Context is the span's.
+type Tracer interface {
+	Start(ctx context.Context, name string, attributes map[string]string) (context.Context, Span)
+}
+
+// A Span is started by a Tracer, and ended with the error from the step, if any.
+type Span interface {
+	End(err error)
+}
+
+// WithTracer returns a copy of the Provider (see Clone) that starts a span with tracer
+// for each rule it calls, or other step it takes, to construct a value.
+// Each span is named after the type being constructed, with these attributes:
+//
+//     provide.rule   "custom" for a rule or factory, or "auto" if the type is automatically provided
+//     provide.phase  "construct" or "initialize", as in TraceEntry
+//
+// The span for a step starts before the dependencies it waits for are constructed,
+// so their spans are its children, and so are the spans for anything a rule constructs while
+// it runs, such as a weak rule's dependencies (see AddWeak). When rules run concurrently
+// (see SetParallelism), only the latter are nested. Every other span starts from
+// the context.Context the Provider provides, if it has one (like the request's,
+// in a child from NewRequestScope), or else from context.Background().
+//
+// With OpenTelemetry, a trace.Tracer only needs a small adapter:
+//
+//     type otelTracer struct{ trace.Tracer }
+//
+//     func (t otelTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, provide.Span) {
+//         ctx, span := t.Tracer.Start(ctx, name)
+//         for k, v := range attributes {
+//             span.SetAttributes(attribute.String(k, v))
+//         }
+//         return ctx, otelSpan{span}
+//     }
+//
+//     type otelSpan struct{ trace.Span }
+//
+//     func (s otelSpan) End(err error) {
+//         if err != nil {
+//             s.RecordError(err)
+//             s.SetStatus(codes.Error, err.Error())
+//         }
+//         s.Span.End()
+//     }
+//
+//     p = p.WithTracer(otelTracer{otel.Tracer("startup")})
+//
+func (p *Provider) WithTracer(tracer Tracer) *Provider {
+	c := p.Clone()
+	c.tracer = tracer
+	return c
+}
+
+// An openSpan is a span that has been started for a task that hasn't been done yet.
+type openSpan struct {
+	ctx  context.Context
+	span Span
+}
+
+// startSpan starts the span for the task at the top of stack, before its dependencies
+// are constructed, so that their spans are its children (see WithTracer).
+// It reports whether it started one.
+func (p *Provider) startSpan(stack []task) bool {
+	if p.tracer == nil {
+		return false
+	}
+	t := stack[len(stack)-1]
+
+	p.traceMu.Lock()
+	defer p.traceMu.Unlock()
+	parent := p.spanCtx
+	for i := len(stack) - 2; i >= 0; i-- {
+		if open, ok := p.spans[stack[i]]; ok {
+			parent = open.ctx
+			break
+		}
+	}
+	if parent == nil {
+		parent = p.baseContext(p.values)
+	}
+
+	ctx, span := p.tracer.Start(parent, t.Type.String(), p.spanAttributes(t))
+	if p.spans == nil {
+		p.spans = make(map[task]openSpan)
+	}
+	p.spans[t] = openSpan{ctx, span}
+	return true
+}
+
+// endSpan ends the span started for a task by startSpan, if it's still open.
+func (p *Provider) endSpan(t task, err error) {
+	p.traceMu.Lock()
+	open, ok := p.spans[t]
+	delete(p.spans, t)
+	p.traceMu.Unlock()
+	if ok {
+		open.span.End(err)
+	}
+}
+
+// spanned wraps do in the span for a task (see WithTracer), starting one if startSpan didn't.
+// Anything do constructs through the Provider itself, like a weak rule's dependencies, is nested under it.
+func (p *Provider) spanned(t task, s state, values map[reflect.Type]reflect.Value, do func() error) func() error {
+	// Concurrent tasks can't tell which of them is running the Provider (see SetParallelism),
+	// so only tasks that run on their own nest what they construct.
+	nests := !p.parallel || s.Exclusive || s.Do == nil
+
+	return func() error {
+		p.traceMu.Lock()
+		outer := p.spanCtx
+		open, ok := p.spans[t]
+		if !ok {
+			parent := outer
+			if parent == nil {
+				parent = p.baseContext(values)
+			}
+			open.ctx, open.span = p.tracer.Start(parent, t.Type.String(), p.spanAttributes(t))
+		}
+		if nests {
+			p.spanCtx = open.ctx
+		}
+		p.traceMu.Unlock()
+
+		err := do()
+
+		p.traceMu.Lock()
+		if nests {
+			p.spanCtx = outer
+		}
+		p.traceMu.Unlock()
+		if !ok {
+			open.span.End(err)
+		}
+		return err
+	}
+}
+
+// baseContext is the context.Context spans start from when they aren't nested under another span.
+func (p *Provider) baseContext(values map[reflect.Type]reflect.Value) context.Context {
+	if v, ok := values[contextType]; ok && !v.IsNil() {
+		return v.Interface().(context.Context)
+	}
+	return context.Background()
+}
+
+func (p *Provider) spanAttributes(t task) map[string]string {
+	attributes := map[string]string{
+		"provide.rule":  "auto",
+		"provide.phase": phase(t),
+	}
+	if _, ok := p.ruleFor(t.Type); ok {
+		attributes["provide.rule"] = "custom"
+	} else if _, ok := p.factory(t.Type); ok {
+		attributes["provide.rule"] = "custom"
+	}
+	return attributes
+}
+
+// phase describes the step a task takes to construct a value (see TraceEntry).
+func phase(t task) string {
+	if t.Complete {
+		return "initialize"
+	}
+	return "construct"
+}
+
+func (p *Provider) traced(t task, s state, values map[reflect.Type]reflect.Value) func() error {
+	do := func() error {
+		return s.Do(values)
+	}
+	if p.tracer != nil {
+		do = p.spanned(t, s, values, do)
+	}
+	// Rules may be run concurrently (see SetParallelism).
+	p.traceMu.Lock()
+	tracing := p.trace != nil
+	p.traceMu.Unlock()
+	if !tracing {
+		return do
+	}
+
+	return func() error {
+		entry := TraceEntry{
+			Type:  t.Type,
+			Phase: phase(t),
+		}
+		for _, dep := range s.DependsOn {
+			if dep.Type != t.Type {
+				entry.Deps = append(entry.Deps, dep.Type)
+			}
+		}
+
+		start := time.Now()
+		entry.Error = do()
+		entry.Duration = time.Since(start)
+
+		p.traceMu.Lock()
+		p.trace = append(p.trace, entry)
+		p.traceMu.Unlock()
+		return entry.Error
+	}
+}
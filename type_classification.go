@@ -1,14 +1,61 @@
 package provide
 
-import "reflect"
+import (
+	"context"
+	"errors"
+	"reflect"
+)
 
 var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 
-func isErrorType(typ reflect.Type) bool {
+// IsErrorType reports whether typ is an interface type that implements error.
+// Outputs of rules with such types are treated as errors rather than values,
+// so they can't be provided.
+func IsErrorType(typ reflect.Type) bool {
 	return typ.Kind() == reflect.Interface && typ.Implements(errorType)
 }
 
-func isReferenceType(typ reflect.Type) bool {
+// IsReferenceType reports whether typ is a pointer, interface, map, or channel type,
+// which are the only types of fields that can be tagged provide:"circular".
+func IsReferenceType(typ reflect.Type) bool {
 	k := typ.Kind()
 	return k == reflect.Ptr || k == reflect.Interface || k == reflect.Map || k == reflect.Chan
 }
+
+func referenceKind(typ reflect.Type) string {
+	switch typ.Kind() {
+	case reflect.Ptr:
+		return "pointer"
+	case reflect.Chan:
+		return "channel"
+	}
+	return typ.Kind().String()
+}
+
+// exampleType returns the type an example pointer points to.
+func exampleType(example interface{}) (reflect.Type, error) {
+	t := reflect.TypeOf(example)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, errors.New("type examples must be pointers to the type, such as (*T)(nil)")
+	}
+	return t.Elem(), nil
+}
+
+// sameSignature reports whether two function types take and return the same types.
+func sameSignature(a, b reflect.Type) bool {
+	if a.NumIn() != b.NumIn() || a.NumOut() != b.NumOut() || a.IsVariadic() != b.IsVariadic() {
+		return false
+	}
+	for i := 0; i < a.NumIn(); i++ {
+		if a.In(i) != b.In(i) {
+			return false
+		}
+	}
+	for i := 0; i < a.NumOut(); i++ {
+		if a.Out(i) != b.Out(i) {
+			return false
+		}
+	}
+	return true
+}
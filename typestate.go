@@ -0,0 +1,107 @@
+package provide
+
+import (
+	"reflect"
+	"sort"
+)
+
+// A TypeState is how far along a Provider is in constructing a type (see TypesWithState).
+type TypeState int
+
+const (
+	// TypeStatePending types haven't been constructed yet.
+	TypeStatePending TypeState = iota
+	// TypeStateInProgress types have started being constructed, but haven't finished,
+	// such as while their dependencies are constructed, or when a dependency failed.
+	TypeStateInProgress
+	// TypeStateDone types have been fully constructed.
+	TypeStateDone
+	// TypeStateErrored types failed to be constructed.
+	TypeStateErrored
+)
+
+func (s TypeState) String() string {
+	switch s {
+	case TypeStatePending:
+		return "pending"
+	case TypeStateInProgress:
+		return "in progress"
+	case TypeStateDone:
+		return "done"
+	case TypeStateErrored:
+		return "errored"
+	default:
+		return "unknown"
+	}
+}
+
+// TypesWithState returns the types that are currently in the given state, sorted by name.
+// Only types with a rule in the Provider, or values added to it directly, are included:
+//
+//     for _, t := range p.TypesWithState(TypeStatePending) {
+//         log.Println("not constructed yet:", t)
+//     }
+//
+func (p *Provider) TypesWithState(state TypeState) []reflect.Type {
+	p.init()
+
+	var types []reflect.Type
+	seen := make(map[reflect.Type]bool)
+	check := func(typ reflect.Type) {
+		if !seen[typ] && p.typeState(typ) == state {
+			types = append(types, typ)
+		}
+		seen[typ] = true
+	}
+	for _, r := range p.rules {
+		for _, out := range r.outputs() {
+			check(out)
+		}
+	}
+	for _, typ := range p.seeded {
+		check(typ)
+	}
+
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].String() < types[j].String()
+	})
+	return types
+}
+
+// GetBuilt returns every type that has been fully constructed, sorted by name,
+// including types that were automatically provided and values added directly.
+// Unlike TypesWithState, it isn't limited to types with rules,
+// so it describes everything that was actually set up, such as which services to shut down:
+//
+//     for _, t := range p.GetBuilt() {
+//         log.Println("started:", t)
+//     }
+//
+func (p *Provider) GetBuilt() []reflect.Type {
+	p.init()
+
+	var types []reflect.Type
+	for typ := range p.values {
+		if p.tasks[task{typ, true}].Done {
+			types = append(types, typ)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].String() < types[j].String()
+	})
+	return types
+}
+
+func (p *Provider) typeState(typ reflect.Type) TypeState {
+	partial, complete := p.tasks[task{typ, false}], p.tasks[task{typ, true}]
+	switch {
+	case complete.Done:
+		return TypeStateDone
+	case p.failed[typ]:
+		return TypeStateErrored
+	case partial.InProgress || partial.Done || complete.InProgress:
+		return TypeStateInProgress
+	default:
+		return TypeStatePending
+	}
+}
@@ -0,0 +1,281 @@
+package provide
+
+import (
+	"errors"
+	"math"
+	"reflect"
+)
+
+// AddValue adds an already constructed value to a Provider,
+// as the value for its own dynamic type:
+//
+//     err := p.AddValue(&Config{Port: 8080})
+//     // *Config will be provided as the given value
+//
+// To add a value for an interface type, use AddTaggedValue, which takes the type explicitly.
+//
+// Unlike AddRule, AddValue never treats a function as a rule: a function given to AddValue
+// is itself the value. This is how to provide values of function types:
+//
+//     type Greeter func(name string) string
+//
+//     err := p.AddValue(Greeter(func(name string) string { return "hi " + name }))
+//     // Greeter is provided as that function, rather than the function
+//     // being used as a rule for string
+//
+func (p *Provider) AddValue(value interface{}) error {
+	p.init()
+
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return errors.New("can't add a nil value without its type; use AddTaggedValue instead")
+	}
+	return p.addValue(v.Type(), v)
+}
+
+// AddTaggedValue adds an already constructed value to a Provider as the value
+// for the type typeExample points to, along with metadata tags describing it,
+// such as where it came from. The tags are included in the Provider's Manifest:
+//
+//     err := p.AddTaggedValue((*Store)(nil), store, map[string]string{
+//         "region": "us-east-1",
+//     })
+//
+func (p *Provider) AddTaggedValue(typeExample interface{}, value interface{}, tags map[string]string) error {
+	p.init()
+
+	typ, err := exampleType(typeExample)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		if typ.Kind() == reflect.Interface {
+			return errors.New(
+				"can't add nil as the value for " + typ.String() + " — a nil interface value cannot be used as a dependency",
+			)
+		}
+		v = reflect.Zero(typ)
+	}
+	if !v.Type().AssignableTo(typ) {
+		return errors.New("can't add a value of type " + v.Type().String() + " as " + typ.String())
+	}
+	if err := p.addValue(typ, v); err != nil {
+		return err
+	}
+
+	p.annotate(typ, tags)
+	return nil
+}
+
+// AddConditionalValue adds a value for the type typeExample points to, like AddTaggedValue without tags,
+// but only if condition is true. Otherwise it does nothing, so that a choice between values
+// can be written as one call for each:
+//
+//     err := p.AddConditionalValue(cfg.UseMockDB, (*DB)(nil), &MockDB{})
+//     err = p.AddConditionalValue(!cfg.UseMockDB, (*DB)(nil), realDB)
+//
+// typeExample is checked either way.
+//
+func (p *Provider) AddConditionalValue(condition bool, typeExample interface{}, value interface{}) error {
+	p.init()
+
+	if _, err := exampleType(typeExample); err != nil || !condition {
+		return err
+	}
+	return p.AddTaggedValue(typeExample, value, nil)
+}
+
+// AddValueFunc adds a rule for the type asType points to, which calls fn
+// the first time the type is needed and uses what it returns as the value:
+//
+//     err := p.AddValueFunc(func() interface{} {
+//         return loadPlugin(name)
+//     }, (*Plugin)(nil))
+//
+// This is for functions that can't easily be written with the exact signature AddRule needs.
+// Providing the type fails if fn returns a value that isn't assignable to it.
+//
+func (p *Provider) AddValueFunc(fn func() interface{}, asType interface{}) error {
+	p.init()
+
+	typ, err := exampleType(asType)
+	if err != nil {
+		return err
+	}
+	if fn == nil {
+		return errors.New("AddValueFunc needs a non-nil function")
+	}
+
+	ruleType := reflect.FuncOf(nil, []reflect.Type{typ, errorType}, false)
+	ruleFn := reflect.MakeFunc(ruleType, func([]reflect.Value) []reflect.Value {
+		v := reflect.ValueOf(fn())
+		if !v.IsValid() {
+			v = reflect.Zero(typ)
+		}
+		if !v.Type().AssignableTo(typ) {
+			err := errors.New("value function for " + typ.String() + " returned a " + v.Type().String())
+			return []reflect.Value{reflect.Zero(typ), reflect.ValueOf(&err).Elem()}
+		}
+
+		out := reflect.New(typ).Elem()
+		out.Set(v)
+		return []reflect.Value{out, reflect.Zero(errorType)}
+	})
+	return p.addRule(rule{Fn: ruleFn.Interface(), IsGenerated: true})
+}
+
+// defaultPriority is the priority of defaults (see AddDefault), which every other rule outranks.
+const defaultPriority = math.MinInt
+
+// AddDefault adds a rule, or an already constructed value, that's only used
+// if nothing else provides the same types. A default is ignored if there's already
+// a rule or value for any of its types, and is replaced by any rule or value added later:
+//
+//     err := p.AddDefault(func() Logger { return nopLogger{} })
+//     err = p.AddDefault(&Config{Port: 8080})
+//
+// Functions are treated as rules, like AddRule, and anything else is treated as
+// the value for its dynamic type, like AddValue.
+//
+func (p *Provider) AddDefault(ruleOrValue interface{}) error {
+	p.init()
+
+	v := reflect.ValueOf(ruleOrValue)
+	if !v.IsValid() {
+		return errors.New("can't add a nil default without its type")
+	}
+	fn := ruleOrValue
+	generated := v.Kind() != reflect.Func
+	if generated {
+		fn = reflect.MakeFunc(reflect.FuncOf(nil, []reflect.Type{v.Type()}, false), func([]reflect.Value) []reflect.Value {
+			return []reflect.Value{v}
+		}).Interface()
+	} else if _, err := customProvide(fn); err != nil {
+		return err
+	}
+
+	for _, out := range ruleOutputs(fn) {
+		if _, ok := p.tasks[task{out, false}]; ok && !p.hasRule(out) {
+			// There's already a value for it, which takes precedence.
+			return nil
+		}
+	}
+	return p.addRule(rule{Fn: fn, Priority: defaultPriority, IsGenerated: generated})
+}
+
+func (p *Provider) annotate(typ reflect.Type, annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	if p.annotations[typ] == nil {
+		p.annotations[typ] = make(map[string]string, len(annotations))
+	}
+	for k, v := range annotations {
+		p.annotations[typ][k] = v
+	}
+}
+
+// DeleteValue removes the value that has been constructed for the type typeExample points to,
+// without removing the rule or other means used to construct it,
+// so the next time the type is needed it's constructed again:
+//
+//     err := p.DeleteValue((**TokenSource)(nil))
+//     err = p.Provide(&tokens) // constructs a new *TokenSource
+//
+// Since every output of a rule is constructed at once, deleting one output of a rule
+// deletes all of them. DeleteValue returns an error if the type hasn't been constructed,
+// if it was added as a value (which couldn't be constructed again),
+// or if another constructed type depends on it directly.
+//
+func (p *Provider) DeleteValue(typeExample interface{}) error {
+	p.init()
+
+	typ, err := exampleType(typeExample)
+	if err != nil {
+		return err
+	}
+	if p.frozen {
+		return errors.New("can't delete values from a Provider after Freeze")
+	}
+	if s, ok := p.tasks[task{typ, true}]; !ok || !s.Done {
+		return errors.New("there's no value for " + typ.String() + " to delete")
+	}
+	for _, seeded := range p.seeded {
+		if seeded == typ {
+			return errors.New(typ.String() + " was added as a value, so it can't be deleted and constructed again")
+		}
+	}
+
+	deleting := []reflect.Type{typ}
+	var initializers []initializer
+	if r, ok := p.ruleFor(typ); ok {
+		// The rule was already added, so it can be set up again the same way.
+		initializers, _ = p.ruleInitializers(r)
+		deleting = deleting[:0]
+		for i, init := range initializers {
+			deleting = append(deleting, init.Type)
+			if r.IsWeak {
+				initializers[i] = p.weaken(init, r.outputs())
+			}
+		}
+	}
+
+	for t, s := range p.tasks {
+		if !s.Done || containsType(deleting, t.Type) {
+			continue
+		}
+		for _, dep := range s.DependsOn {
+			if containsType(deleting, dep.Type) {
+				return errors.New("can't delete " + dep.Type.String() + " because " + t.Type.String() + " depends on it")
+			}
+		}
+	}
+
+	for _, t := range deleting {
+		delete(p.tasks, task{t, false})
+		delete(p.tasks, task{t, true})
+		delete(p.values, t)
+	}
+	for _, init := range initializers {
+		p.set(init)
+	}
+
+	// The deleted types will be in the history again when they're constructed again (see Replay).
+	history := p.history[:0]
+	for _, step := range p.history {
+		if !stepUses(step, deleting) {
+			history = append(history, step)
+		}
+	}
+	p.history = history
+	return nil
+}
+
+// stepUses reports whether a step constructed or depended on any of types.
+func stepUses(s step, types []reflect.Type) bool {
+	if containsType(types, s.Task.Type) {
+		return true
+	}
+	for _, out := range s.State.Outputs {
+		if containsType(types, out) {
+			return true
+		}
+	}
+	for _, dep := range s.State.DependsOn {
+		if containsType(types, dep.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsType(types []reflect.Type, typ reflect.Type) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
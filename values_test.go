@@ -0,0 +1,369 @@
+package provide_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/MatthewValentine/provide"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAddValue(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddValue(KrabbyPatty("jabberwocky"))
+	assert(t, err == nil, err)
+	err = p.AddValue(KrabbyPatty("bandersnatch"))
+	assert(t, err != nil, "there's already a KrabbyPatty")
+	err = p.AddValue(nil)
+	assert(t, err != nil, "nil has no type")
+
+	err = p.AddTaggedValue((*UnderSea)(nil), Patrick{Patty: "pizza"}, map[string]string{"home": "rock"})
+	assert(t, err == nil, err)
+	err = p.AddTaggedValue((*InPineapple)(nil), Patrick{}, nil)
+	assert(t, err != nil, "Patrick doesn't live in a pineapple")
+
+	var sp *Spongebob
+	var us UnderSea
+	err = p.Provide(&sp, &us)
+	assert(t, err == nil, err)
+	assert(t, sp.Patty == "jabberwocky", sp)
+	assert(t, us.(Patrick).Patty == "pizza", us)
+
+	m := p.Manifest()
+	assert(t, len(m.Rules) == 0, m)
+	assert(t, len(m.Values) == 2, m)
+	assert(t, m.Values[0].Type == reflect.TypeOf(KrabbyPatty("")) && m.Values[0].Annotations == nil, m)
+	assert(t, m.Values[1].Type == reflect.TypeOf((*UnderSea)(nil)).Elem() && m.Values[1].Annotations["home"] == "rock", m)
+}
+
+func TestDiff(t *testing.T) {
+	before := &provide.Provider{}
+	err := before.AddRule(func() KrabbyPatty { return "pickles" })
+	assert(t, err == nil, err)
+	err = before.AddValue(Patrick{})
+	assert(t, err == nil, err)
+
+	after := &provide.Provider{}
+	err = after.AddRule(func(pt Patrick) KrabbyPatty { return pt.Patty })
+	assert(t, err == nil, err)
+	err = after.AddValue(&Spongebob{})
+	assert(t, err == nil, err)
+
+	d := before.Diff(after)
+	data, err := json.Marshal(d)
+	assert(t, err == nil, err)
+	assert(t, string(data) == `[`+
+		`{"type":"*provide_test.Spongebob","change":"added"},`+
+		`{"type":"provide_test.KrabbyPatty","change":"modified","details":"changed from a rule taking () to a rule taking (provide_test.Patrick)"},`+
+		`{"type":"provide_test.Patrick","change":"removed"}]`, string(data))
+
+	data, err = json.Marshal(after.Diff(after))
+	assert(t, err == nil && string(data) == "[]", string(data), err)
+}
+
+func TestDeleteValue(t *testing.T) {
+	calls := 0
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		calls++
+		return KrabbyPatty("patty " + strconv.Itoa(calls))
+	})
+	assert(t, err == nil, err)
+
+	err = p.DeleteValue((*KrabbyPatty)(nil))
+	assert(t, err != nil, "nothing has been constructed yet")
+
+	var kp KrabbyPatty
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "patty 1", kp, err)
+	err = p.DeleteValue((*KrabbyPatty)(nil))
+	assert(t, err == nil, err)
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "patty 2", kp, err)
+	err = p.Replay()
+	assert(t, err == nil && calls == 3, calls, err)
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "patty 3", kp, err)
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	err = p.DeleteValue((*KrabbyPatty)(nil))
+	assert(t, err != nil, "*Spongebob depends on KrabbyPatty")
+	err = p.DeleteValue((**Spongebob)(nil))
+	assert(t, err == nil, err)
+	var again *Spongebob
+	err = p.Provide(&again)
+	assert(t, err == nil && again != sp && again.Patty == "patty 3", again, err)
+
+	err = p.AddValue(&Patrick{})
+	assert(t, err == nil, err)
+	err = p.DeleteValue((**Patrick)(nil))
+	assert(t, err != nil, "*Patrick was added as a value")
+}
+
+func TestAddValueFunc(t *testing.T) {
+	calls := 0
+	p := &provide.Provider{}
+	err := p.AddValueFunc(func() interface{} {
+		calls++
+		return Patrick{Patty: "jabberwocky"}
+	}, (*UnderSea)(nil))
+	assert(t, err == nil, err)
+	assert(t, calls == 0, "the value function is called lazily")
+
+	var us UnderSea
+	err = p.Provide(&us)
+	assert(t, err == nil, err)
+	assert(t, calls == 1 && us.(Patrick).Patty == "jabberwocky", calls, us)
+
+	err = p.AddValueFunc(func() interface{} { return 42 }, (*KrabbyPatty)(nil))
+	assert(t, err == nil, err)
+	var kp KrabbyPatty
+	err = p.Provide(&kp)
+	assert(t, err != nil, "42 isn't a KrabbyPatty")
+
+	// The rule for an interface type doesn't count as suspicious, since it isn't written by hand.
+	p, err = provide.NewProvider(provide.WithStrictMode())
+	assert(t, err == nil, err)
+	err = p.AddValueFunc(func() interface{} { return Patrick{} }, (*UnderSea)(nil))
+	assert(t, err == nil, err)
+}
+
+func TestTypesWithState(t *testing.T) {
+	p, err := provide.NewProvider(
+		func() KrabbyPatty { return "jabberwocky" },
+		func(kp KrabbyPatty) (*Patrick, error) { return nil, errors.New("asleep") },
+		func(kp KrabbyPatty) InPineapple { return &Spongebob{Patty: kp} },
+	)
+	assert(t, err == nil, err)
+	err = p.AddValue(Squidward{})
+	assert(t, err == nil, err)
+
+	pending := p.TypesWithState(provide.TypeStatePending)
+	assert(t, len(pending) == 3, pending)
+
+	var ip InPineapple
+	var star *Patrick
+	err = p.Provide(&ip, &star)
+	assert(t, err != nil, "Patrick is asleep")
+
+	done := p.TypesWithState(provide.TypeStateDone)
+	assert(t, len(done) == 3, done)
+	assert(t, done[0] == reflect.TypeOf((*InPineapple)(nil)).Elem(), done)
+	assert(t, done[1] == reflect.TypeOf(KrabbyPatty("")), done)
+	assert(t, done[2] == reflect.TypeOf(Squidward{}), done)
+
+	errored := p.TypesWithState(provide.TypeStateErrored)
+	assert(t, len(errored) == 1 && errored[0] == reflect.TypeOf(star), errored)
+	assert(t, len(p.TypesWithState(provide.TypeStatePending)) == 0)
+	assert(t, provide.TypeStateErrored.String() == "errored")
+}
+
+type Foghorn func(KrabbyPatty) string
+
+func TestAddValueOfFuncType(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddValue(Foghorn(func(kp KrabbyPatty) string { return "ahoy " + string(kp) }))
+	assert(t, err == nil, err)
+	err = p.AddValue(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	var horn Foghorn
+	var fn func() KrabbyPatty
+	err = p.Provide(&horn, &fn)
+	assert(t, err == nil, err)
+	assert(t, horn(fn()) == "ahoy jabberwocky", horn(fn()))
+
+	var kp KrabbyPatty
+	err = p.Provide(&kp)
+	assert(t, err != nil, "the function is a value, not a rule for KrabbyPatty")
+}
+
+func TestGroupBy(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddTaggedValue((*UnderSea)(nil), Patrick{}, map[string]string{"home": "rock"})
+	assert(t, err == nil, err)
+	err = p.AddTaggedValue((*KrabbyPatty)(nil), KrabbyPatty("pickles"), map[string]string{"home": "krusty krab"})
+	assert(t, err == nil, err)
+	err = p.AddTaggedValue((**Spongebob)(nil), &Spongebob{}, map[string]string{"home": "pineapple", "job": "fry cook"})
+	assert(t, err == nil, err)
+	err = p.AddTaggedValue((*Squidward)(nil), Squidward{}, map[string]string{"job": "cashier"})
+	assert(t, err == nil, err)
+
+	groups := p.GroupBy("home")
+	assert(t, len(groups) == 3, groups)
+	assert(t, len(groups["rock"]) == 1 && groups["rock"][0] == reflect.TypeOf((*UnderSea)(nil)).Elem(), groups)
+	assert(t, len(groups["pineapple"]) == 1 && groups["pineapple"][0] == reflect.TypeOf(&Spongebob{}), groups)
+
+	groups = p.GroupBy("job")
+	assert(t, len(groups) == 2 && len(groups["cashier"]) == 1, groups)
+	assert(t, len(p.GroupBy("pet")) == 0)
+}
+
+func TestAnnotateType(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	err = p.AnnotateType((*KrabbyPatty)(nil), map[string]string{"owner": "krabs", "critical": "true"})
+	assert(t, err == nil, err)
+	err = p.AnnotateType((*KrabbyPatty)(nil), map[string]string{"critical": "very"})
+	assert(t, err == nil, err)
+	err = p.AnnotateType((**Spongebob)(nil), map[string]string{"owner": "krabs"})
+	assert(t, err != nil, "*Spongebob is only provided automatically")
+
+	m := p.Manifest()
+	assert(t, len(m.Rules) == 1, m)
+	assert(t, m.Rules[0].Annotations["owner"] == "krabs" && m.Rules[0].Annotations["critical"] == "very", m.Rules[0].Annotations)
+	assert(t, len(p.GroupBy("owner")["krabs"]) == 1, p.GroupBy("owner"))
+}
+
+func TestUnmarshalRules(t *testing.T) {
+	registry := provide.RuleRegistry{
+		"jabberwocky": func() KrabbyPatty { return "jabberwocky" },
+		"chum":        func() KrabbyPatty { return "chum" },
+		"patrick":     func() UnderSea { return Patrick{} },
+	}
+
+	p := &provide.Provider{}
+	err := p.UnmarshalRules([]byte(`{"jabberwocky": false, "chum": true}`), registry)
+	assert(t, err == nil, err)
+	var kp KrabbyPatty
+	err = p.Provide(&kp)
+	assert(t, err == nil && kp == "chum", kp, err)
+	var us UnderSea
+	err = p.Provide(&us)
+	assert(t, err != nil, "patrick wasn't enabled")
+
+	p = &provide.Provider{}
+	err = p.UnmarshalRules([]byte(`{"chum": true, "plankton": true}`), registry)
+	assert(t, err != nil, "plankton isn't registered")
+	assert(t, len(p.Manifest().Rules) == 0, "nothing is added")
+	err = p.UnmarshalRules([]byte(`["chum"]`), registry)
+	assert(t, err != nil, "not an object")
+	err = p.UnmarshalRules([]byte(`{"chum": true, "jabberwocky": true}`), registry)
+	assert(t, err != nil, "conflicting rules")
+	assert(t, len(p.Manifest().Rules) == 0, "nothing is added")
+}
+
+func TestAddDefault(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddDefault(KrabbyPatty("jabberwocky"))
+	assert(t, err == nil, err)
+	err = p.AddDefault(func() UnderSea { return Patrick{} })
+	assert(t, err == nil, err)
+	err = p.AddValue(KrabbyPatty("pizza"))
+	assert(t, err == nil, "values replace defaults", err)
+	err = p.AddRule(func(kp KrabbyPatty) UnderSea { return Patrick{Patty: kp} })
+	assert(t, err == nil, "rules replace defaults", err)
+	err = p.AddDefault(KrabbyPatty("chum"))
+	assert(t, err == nil, "ignored", err)
+	err = p.AddDefault(func() Squidward { return Squidward{} })
+	assert(t, err == nil, err)
+
+	var us UnderSea
+	var squid Squidward
+	err = p.Provide(&us, &squid)
+	assert(t, err == nil && us.(Patrick).Patty == "pizza", us, err)
+
+	err = p.AddDefault((func() KrabbyPatty)(nil))
+	assert(t, err != nil, "nil function")
+}
+
+func TestInspect(t *testing.T) {
+	p, err := provide.NewProvider(func(sq Squidward) KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+	err = p.AddValue(Squidward{})
+	assert(t, err == nil, err)
+
+	data, err := json.Marshal(p.Inspect())
+	assert(t, err == nil, err)
+	assert(t, string(data) == `{"rules":{"provide_test.KrabbyPatty":["provide_test.Squidward"]},"values":["provide_test.Squidward"],"pending":["provide_test.KrabbyPatty"]}`, string(data))
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	state := p.Inspect()
+	assert(t, len(state.Values) == 3 && state.Values[0] == "*provide_test.Spongebob" && len(state.Pending) == 0, state)
+
+	err = p.AddPrototype(func(kp KrabbyPatty) func() *Ticket {
+		return func() *Ticket { return &Ticket{} }
+	})
+	assert(t, err == nil, err)
+	state = p.Inspect()
+	ins := state.Rules["*provide_test.Ticket"]
+	assert(t, len(ins) == 1 && ins[0] == "func() *provide_test.Ticket", "a prototype's type is constructed from the prototype function", state.Rules)
+	ins = state.Rules["func() *provide_test.Ticket"]
+	assert(t, len(ins) == 1 && ins[0] == "provide_test.KrabbyPatty", state.Rules)
+
+	data, err = p.MarshalDependencyGraph()
+	assert(t, err == nil, err)
+	assert(t, strings.Contains(string(data), `"*provide_test.Ticket":["func() *provide_test.Ticket"]`), string(data))
+	for _, r := range p.Manifest().Rules {
+		if r.Type == reflect.TypeOf(&Ticket{}) {
+			assert(t, len(r.Inputs) == 1 && r.Inputs[0] == reflect.TypeOf(func() *Ticket { return nil }), r.Inputs)
+		}
+	}
+}
+
+func TestGetBuilt(t *testing.T) {
+	p, err := provide.NewProvider(
+		func() KrabbyPatty { return "jabberwocky" },
+		func(kp KrabbyPatty) (*Patrick, error) { return nil, errors.New("asleep") },
+	)
+	assert(t, err == nil, err)
+	err = p.AddValue(Squidward{})
+	assert(t, err == nil, err)
+	assert(t, len(p.GetBuilt()) == 1, p.GetBuilt())
+
+	var sp *Spongebob
+	var star *Patrick
+	err = p.Provide(&sp, &star)
+	assert(t, err != nil, "Patrick is asleep")
+
+	built := p.GetBuilt()
+	assert(t, len(built) == 3, built)
+	assert(t, built[0] == reflect.TypeOf(sp) && built[1] == reflect.TypeOf(KrabbyPatty("")) && built[2] == reflect.TypeOf(Squidward{}), built)
+}
+
+func TestTag(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty { return "jabberwocky" })
+	assert(t, err == nil, err)
+
+	err = p.Tag((*KrabbyPatty)(nil), "owner", "krabs")
+	assert(t, err == nil, err)
+	err = p.Tag((*KrabbyPatty)(nil), "secret", "true")
+	assert(t, err == nil, err)
+	err = p.Tag((**Spongebob)(nil), "job", "fry cook")
+	assert(t, err != nil, "*Spongebob hasn't been needed yet")
+
+	var sp *Spongebob
+	err = p.Provide(&sp)
+	assert(t, err == nil, err)
+	err = p.Tag((**Spongebob)(nil), "job", "fry cook")
+	assert(t, err == nil, err)
+
+	m := p.Manifest()
+	assert(t, len(m.Rules[0].Annotations) == 2 && m.Rules[0].Annotations["secret"] == "true", m)
+	state := p.Inspect()
+	assert(t, len(state.Tags) == 2 && state.Tags["*provide_test.Spongebob"]["job"] == "fry cook", state.Tags)
+}
+
+func TestAddConditionalValue(t *testing.T) {
+	p := &provide.Provider{}
+	err := p.AddConditionalValue(false, (*UnderSea)(nil), Patrick{Patty: "pizza"})
+	assert(t, err == nil, err)
+	err = p.AddConditionalValue(true, (*UnderSea)(nil), Patrick{Patty: "chum"})
+	assert(t, err == nil, err)
+	err = p.AddConditionalValue(false, UnderSea(nil), Patrick{})
+	assert(t, err != nil, "not a type example")
+	err = p.AddConditionalValue(true, (*InPineapple)(nil), nil)
+	assert(t, err != nil, "nil interface values can't be added")
+	err = p.AddConditionalValue(true, (**Patrick)(nil), nil)
+	assert(t, err == nil, "nil pointers can be added")
+
+	var us UnderSea
+	err = p.Provide(&us)
+	assert(t, err == nil && us.(Patrick).Patty == "chum", us, err)
+}
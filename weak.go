@@ -0,0 +1,106 @@
+package provide
+
+import "reflect"
+
+// Metrics holds counts of notable events in the life of a Provider.
+type Metrics struct {
+	// WeakRuleFailures counts the weak rules (see AddWeak) that
+	// couldn't be used, either because they returned an error,
+	// or because their dependencies couldn't be provided.
+	WeakRuleFailures int
+}
+
+// Metrics returns the Provider's current metrics.
+func (p *Provider) Metrics() Metrics {
+	return p.metrics
+}
+
+// AddWeak is like AddRule, except that failing to use the rule is not an error.
+// If the rule returns an error, or its dependencies can't be provided,
+// its outputs are provided as zero values instead, the failure is counted
+// in Metrics().WeakRuleFailures, and a warning is given to any OnWarning functions.
+//
+// This is meant for optional components, such as instrumentation,
+// that the rest of an application can do without:
+//
+//     p.AddWeak(func(config *Config) (*Tracer, error) {
+//         return dialTracer(config.TracerAddress)
+//     })
+//
+//     func (s *Server) PleaseProvide(tracer *Tracer) {
+//         s.tracer = tracer // nil if it couldn't be set up
+//     }
+//
+func (p *Provider) AddWeak(weakFn interface{}) error {
+	p.init()
+	return p.addRule(rule{Fn: weakFn, IsWeak: true})
+}
+
+// weaken changes a rule's initializer so that failures produce a zero value.
+// outputs are all the types the rule outputs, which share its failure.
+func (p *Provider) weaken(init initializer, outputs []reflect.Type) initializer {
+	deps := init.Partial.DependsOn
+	do := init.Partial.Do
+	init.Partial = state{
+		Outputs:   outputs,
+		Exclusive: true,
+		Do: func(values map[reflect.Type]reflect.Value) error {
+			err := p.try(func() error {
+				for _, dep := range deps {
+					if err := p.complete(dep.Type); err != nil {
+						return err
+					}
+				}
+				return do(values)
+			})
+			if err != nil {
+				p.metrics.WeakRuleFailures++
+				p.notify("weak rule for " + init.Type.String() + " failed: " + err.Error())
+				for _, out := range outputs {
+					values[out] = reflect.Zero(out)
+				}
+			}
+			return nil
+		},
+	}
+	return init
+}
+
+// try calls fn, undoing everything the Provider did during the call if it fails.
+func (p *Provider) try(fn func() error) error {
+	tasks := make(map[task]state, len(p.tasks))
+	for t, s := range p.tasks {
+		tasks[t] = s
+	}
+	values := make(map[reflect.Type]bool, len(p.values))
+	for t := range p.values {
+		values[t] = true
+	}
+	history := len(p.history)
+	failed := make(map[reflect.Type]bool, len(p.failed))
+	for t := range p.failed {
+		failed[t] = true
+	}
+	p.traceMu.Lock()
+	trace := len(p.trace)
+	p.traceMu.Unlock()
+
+	// Any spans fn opened are ended by do, with the error that stopped them.
+	err := fn()
+	if err != nil {
+		p.tasks = tasks
+		for t := range p.values {
+			if !values[t] {
+				delete(p.values, t)
+			}
+		}
+		p.history = p.history[:history]
+		p.failed = failed
+		p.traceMu.Lock()
+		if p.trace != nil && len(p.trace) > trace {
+			p.trace = p.trace[:trace]
+		}
+		p.traceMu.Unlock()
+	}
+	return err
+}
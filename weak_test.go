@@ -0,0 +1,61 @@
+package provide_test
+
+import (
+	"errors"
+	"github.com/MatthewValentine/provide"
+	"testing"
+)
+
+func TestWeak(t *testing.T) {
+	p, err := provide.NewProvider(func() KrabbyPatty {
+		return "jabberwocky"
+	})
+	assert(t, err == nil, err)
+
+	var warnings []string
+	p.OnWarning(func(warning string) {
+		warnings = append(warnings, warning)
+	})
+
+	err = p.AddWeak(func(kp KrabbyPatty) (*Gary, *Order, error) {
+		return nil, nil, errors.New("Gary ran away")
+	})
+	assert(t, err == nil, err)
+
+	err = p.AddWeak(func(sp *Spongebob, plankton *Plankton) *ChumBucket {
+		return &ChumBucket{}
+	})
+	assert(t, err == nil, err)
+
+	var gary *Gary
+	var order *Order
+	var chum *ChumBucket
+	var sp *Spongebob
+	err = p.Provide(&gary, &order, &chum, &sp)
+	assert(t, err == nil, err)
+	assert(t, gary == nil && order == nil && chum == nil, gary, order, chum)
+	assert(t, sp.Patty == "jabberwocky", sp)
+	assert(t, p.Metrics().WeakRuleFailures == 2, p.Metrics())
+	assert(t, len(warnings) == 2, warnings)
+
+	p, err = provide.NewProvider(
+		func() (*Karen, error) { return nil, errors.New("unplugged") },
+	)
+	assert(t, err == nil, err)
+	err = p.AddWeak(func(karen *Karen) *ChumBucket {
+		return &ChumBucket{}
+	})
+	assert(t, err == nil, err)
+	err = p.Provide(&chum)
+	assert(t, err == nil && chum == nil, chum, err)
+	errored := p.TypesWithState(provide.TypeStateErrored)
+	assert(t, len(errored) == 0, "the failure of *Karen was undone along with the rest of the weak rule", errored)
+}
+
+type Karen struct{}
+
+type Gary struct{}
+
+type Plankton struct{}
+
+type ChumBucket struct{}